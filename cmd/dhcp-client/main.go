@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
+	"os"
+	"time"
 
 	"../../pkg/dhcp/dhcpv4"
 )
@@ -16,6 +20,8 @@ func main() {
 	c := &dhcpv4.Client{
 		Interface:      i,
 		NoAutoHostname: true,
+		OfferWindow:    2 * time.Second,
+		Logger:         slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
 		Options: map[uint8]interface{}{
 			dhcpv4.OptionParameterList: []byte{
 				dhcpv4.OptionSubnetMask,
@@ -29,7 +35,10 @@ func main() {
 		},
 	}
 
-	packets, err := c.Discover()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	packets, err := c.Discover(ctx)
 	if err != nil {
 		panic(err)
 	}
@@ -1,5 +1,64 @@
 package main
 
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"../../pkg/dhcp/dhcpv4"
+)
+
 func main() {
+	ifname := flag.String("interface", "eth0", "interface to serve")
+	start := flag.String("start", "192.168.1.100", "first address of the pool")
+	end := flag.String("end", "192.168.1.200", "last address of the pool")
+	mask := flag.String("mask", "255.255.255.0", "subnet mask")
+	router := flag.String("router", "", "default router address")
+	dns := flag.String("dns", "", "DNS server address")
+	leaseTime := flag.Duration("lease-time", 12*time.Hour, "lease time")
+	leaseFile := flag.String("lease-file", "", "file to persist leases in")
+	debug := flag.Bool("debug", false, "log every packet")
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	i, err := net.InterfaceByName(*ifname)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &dhcpv4.Server{
+		Interface:  i,
+		PoolStart:  net.ParseIP(*start),
+		PoolEnd:    net.ParseIP(*end),
+		SubnetMask: net.IPMask(net.ParseIP(*mask).To4()),
+		LeaseTime:  *leaseTime,
+		Options:    map[uint8]interface{}{},
+		Logger:     logger,
+	}
+	if ip := net.ParseIP(*router).To4(); ip != nil {
+		s.Options[dhcpv4.OptionRouters] = []byte(ip)
+	}
+	if ip := net.ParseIP(*dns).To4(); ip != nil {
+		s.Options[dhcpv4.OptionDomainNameServers] = []byte(ip)
+	}
+
+	if *leaseFile != "" {
+		leases, err := dhcpv4.OpenFileLeaseStore(*leaseFile, logger)
+		if err != nil {
+			panic(err)
+		}
+		defer leases.Close()
+		s.Leases = leases
+	}
 
+	if err := s.ListenAndServe(); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,277 @@
+package dhcpv4
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Authentication protocols, algorithms and replay detection methods (RFC3118)
+const (
+	AuthProtocolConfigToken uint8 = 0 // RFC3118 section 4
+	AuthProtocolDelayed     uint8 = 1 // RFC3118 section 5
+
+	AuthAlgorithmHMACMD5 uint8 = 1
+
+	AuthRDMCounter uint8 = 0 // monotonically increasing counter
+)
+
+// authHeaderLen is the length of the protocol, algorithm, RDM and replay detection fields
+const authHeaderLen = 11
+
+// authDelayedInfoLen is the length of the secret ID and HMAC of delayed authentication
+const authDelayedInfoLen = 4 + md5.Size
+
+// ErrAuthentication is returned when a message fails authentication
+var ErrAuthentication = errors.New("Authentication failed")
+
+var (
+	replayMu   sync.Mutex
+	lastReplay uint64
+)
+
+// nextReplayDetection returns a strictly increasing replay detection value.
+// The value is based on the current time, so it keeps increasing across restarts.
+func nextReplayDetection() uint64 {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	v := uint64(time.Now().UnixNano())
+	if v <= lastReplay {
+		v = lastReplay + 1
+	}
+	lastReplay = v
+	return v
+}
+
+// Authentication is the value of OptionAuthentication (RFC3118)
+type Authentication struct {
+	Protocol        uint8
+	Algorithm       uint8
+	RDM             uint8
+	ReplayDetection uint64
+	Info            []byte
+}
+
+// SecretID returns the secret ID of delayed authentication information
+func (a *Authentication) SecretID() (uint32, error) {
+	if a.Protocol != AuthProtocolDelayed || len(a.Info) != authDelayedInfoLen {
+		return 0, errors.New("No delayed authentication information")
+	}
+	return binary.BigEndian.Uint32(a.Info), nil
+}
+
+func (a *Authentication) encode() ([]byte, error) {
+	buf := []byte{a.Protocol, a.Algorithm, a.RDM}
+	buf = binary.BigEndian.AppendUint64(buf, a.ReplayDetection)
+	return append(buf, a.Info...), nil
+}
+
+// decodeAuthentication decodes the value of OptionAuthentication
+func decodeAuthentication(v []byte) (*Authentication, error) {
+	if len(v) < authHeaderLen {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), OptionAuthentication)
+	}
+	return &Authentication{
+		Protocol:        v[0],
+		Algorithm:       v[1],
+		RDM:             v[2],
+		ReplayDetection: binary.BigEndian.Uint64(v[3:11]),
+		Info:            append([]byte{}, v[authHeaderLen:]...),
+	}, nil
+}
+
+// Authentication decodes OptionAuthentication
+func (o Options) Authentication() (*Authentication, error) {
+	v, err := o.Bytes(OptionAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAuthentication(v)
+}
+
+// Authentication decodes OptionAuthentication of the packet
+func (p *Packet) Authentication() (*Authentication, error) {
+	return p.GetOptions().Authentication()
+}
+
+// A KeyStore provides the secrets shared between clients and servers for delayed authentication
+type KeyStore interface {
+	// Key returns the secret identified by id, or nil if it is unknown
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyStore is a KeyStore of secrets keyed by secret ID
+type StaticKeyStore map[uint32][]byte
+
+// Key implements KeyStore
+func (s StaticKeyStore) Key(id uint32) ([]byte, error) {
+	return s[id], nil
+}
+
+// RequestAuthentication adds OptionAuthentication without authentication information to
+// the packet, which asks the server to use delayed authentication (RFC3118 section 5.3)
+func (p *Packet) RequestAuthentication() error {
+	opts := p.GetOptions()
+	opts[OptionAuthentication] = &Authentication{
+		Protocol:        AuthProtocolDelayed,
+		Algorithm:       AuthAlgorithmHMACMD5,
+		RDM:             AuthRDMCounter,
+		ReplayDetection: nextReplayDetection(),
+	}
+	if err := p.SetOptions(opts); err != nil {
+		return fmt.Errorf("Packet.SetOptions: %v", err)
+	}
+	return nil
+}
+
+// Sign adds OptionAuthentication to the packet, authenticating it using delayed
+// authentication with HMAC-MD5 and the secret identified by id (RFC3118 section 5)
+func (p *Packet) Sign(id uint32, key []byte) error {
+	return p.sign(id, key, int(dhcpFixedLen)+len(p.Options))
+}
+
+// sign authenticates the packet, laying out its options in at most maxSize bytes
+func (p *Packet) sign(id uint32, key []byte, maxSize int) error {
+	auth := &Authentication{
+		Protocol:        AuthProtocolDelayed,
+		Algorithm:       AuthAlgorithmHMACMD5,
+		RDM:             AuthRDMCounter,
+		ReplayDetection: nextReplayDetection(),
+		Info:            make([]byte, authDelayedInfoLen),
+	}
+	binary.BigEndian.PutUint32(auth.Info, id)
+
+	// the HMAC is computed with its own field set to zero, and setting it
+	// afterwards does not change the layout of the options
+	opts := p.GetOptions()
+	opts[OptionAuthentication] = auth
+	if err := p.SetOptionsMaxSize(opts, maxSize); err != nil {
+		return fmt.Errorf("Packet.SetOptionsMaxSize: %v", err)
+	}
+	data, err := p.toBytes()
+	if err != nil {
+		return fmt.Errorf("packet.toBytes: %v", err)
+	}
+	copy(auth.Info[4:], authMAC(data, key))
+	if err := p.SetOptionsMaxSize(opts, maxSize); err != nil {
+		return fmt.Errorf("Packet.SetOptionsMaxSize: %v", err)
+	}
+	return nil
+}
+
+// authMAC computes the HMAC-MD5 of a message with the hops and giaddr fields set to zero
+func authMAC(data []byte, key []byte) []byte {
+	msg := append([]byte{}, data...)
+	msg[unsafe.Offsetof(Packet{}.Hops)] = 0
+	giaddr := unsafe.Offsetof(Packet{}.GatewayIP)
+	copy(msg[giaddr:giaddr+4], []byte{0, 0, 0, 0})
+
+	h := hmac.New(md5.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// findOption returns the offset and length of the value of an option in a raw
+// message, looking in the options field and the fields it overloads, or -1
+func findOption(data []byte, code uint8) (int, int) {
+	field := func(start, end uintptr) []byte {
+		if int(start) >= len(data) {
+			return nil
+		}
+		if int(end) > len(data) {
+			end = uintptr(len(data))
+		}
+		return data[start:end]
+	}
+	find := func(buf []byte, code uint8) (int, int) {
+		for idx := 0; idx+1 < len(buf); {
+			switch buf[idx] {
+			case OptionEnd:
+				return -1, 0
+			case OptionPad:
+				idx++
+				continue
+			}
+			n := int(buf[idx+1])
+			if idx+2+n > len(buf) {
+				return -1, 0
+			}
+			if buf[idx] == code {
+				return idx + 2, n
+			}
+			idx += 2 + n
+		}
+		return -1, 0
+	}
+
+	options := unsafe.Offsetof(Packet{}.Options) + uintptr(len(dhcpCookie))
+	file := unsafe.Offsetof(Packet{}.BootFilename)
+	sname := unsafe.Offsetof(Packet{}.ServerHostname)
+
+	if off, n := find(field(options, uintptr(len(data))), code); off >= 0 {
+		return int(options) + off, n
+	}
+	var overload uint8
+	if off, n := find(field(options, uintptr(len(data))), OptionOverload); off >= 0 && n == 1 {
+		overload = data[int(options)+off]
+	}
+	if overload&overloadFile != 0 {
+		if off, n := find(field(file, file+uintptr(len(Packet{}.BootFilename))), code); off >= 0 {
+			return int(file) + off, n
+		}
+	}
+	if overload&overloadSname != 0 {
+		if off, n := find(field(sname, sname+uintptr(len(Packet{}.ServerHostname))), code); off >= 0 {
+			return int(sname) + off, n
+		}
+	}
+	return -1, 0
+}
+
+// VerifyAuthentication verifies the delayed authentication of a raw message using
+// the secrets in keys, and returns its OptionAuthentication. To detect replayed
+// messages, its replay detection value must be greater than last.
+func VerifyAuthentication(data []byte, keys KeyStore, last uint64) (*Authentication, error) {
+	if len(data) < int(dhcpFixedNonUDP)+len(dhcpCookie) {
+		return nil, ErrAuthentication
+	}
+	off, n := findOption(data, OptionAuthentication)
+	if off < 0 {
+		return nil, ErrAuthentication
+	}
+	auth, err := decodeAuthentication(data[off : off+n])
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	if auth.Protocol != AuthProtocolDelayed || auth.Algorithm != AuthAlgorithmHMACMD5 || auth.RDM != AuthRDMCounter {
+		return nil, ErrAuthentication
+	}
+	id, err := auth.SecretID()
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	if auth.ReplayDetection <= last {
+		return nil, ErrAuthentication
+	}
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("KeyStore.Key: %v", err)
+	}
+	if key == nil {
+		return nil, ErrAuthentication
+	}
+
+	msg := append([]byte{}, data...)
+	mac := off + authHeaderLen + 4
+	copy(msg[mac:mac+md5.Size], make([]byte, md5.Size))
+	if !hmac.Equal(authMAC(msg, key), auth.Info[4:]) {
+		return nil, ErrAuthentication
+	}
+	return auth, nil
+}
@@ -0,0 +1,137 @@
+package dhcpv4
+
+import (
+	"testing"
+	"unsafe"
+)
+
+var testKeys = StaticKeyStore{7: []byte("shared secret")}
+
+// signedMessage returns a raw message authenticated with secret 7 of testKeys,
+// with its options laid out in at most maxSize bytes
+func signedMessage(t *testing.T, opts Options, maxSize int) []byte {
+	t.Helper()
+
+	p := &Packet{Operation: OpRequest, HardwareType: HardwareTypeEthernet, HardwareLength: 6, TransactionID: 0x12345678}
+	copy(p.ClientHardwareAddress[:], []byte{0x02, 0, 0, 0, 0, 1})
+	if err := p.SetOptions(opts); err != nil {
+		t.Fatalf("SetOptions: %v", err)
+	}
+	if err := p.sign(7, testKeys[7], maxSize); err != nil {
+		t.Fatalf("Packet.sign: %v", err)
+	}
+	data, err := p.toBytes()
+	if err != nil {
+		t.Fatalf("packet.toBytes: %v", err)
+	}
+	return data
+}
+
+func requestMessage(t *testing.T) []byte {
+	return signedMessage(t, Options{
+		OptionMessageType: MessageTypeRequest,
+		OptionHostname:    "client",
+	}, int(dhcpFixedLen)+int(dhcpOptionsLenMax))
+}
+
+func TestVerifyAuthentication(t *testing.T) {
+	data := requestMessage(t)
+
+	auth, err := VerifyAuthentication(data, testKeys, 0)
+	if err != nil {
+		t.Fatalf("VerifyAuthentication: %v", err)
+	}
+	if id, err := auth.SecretID(); err != nil || id != 7 {
+		t.Errorf("SecretID = %d, %v, want 7", id, err)
+	}
+
+	// relay agents may change the hops and giaddr fields
+	data[unsafe.Offsetof(Packet{}.Hops)]++
+	copy(data[unsafe.Offsetof(Packet{}.GatewayIP):], []byte{192, 0, 2, 1})
+	if _, err := VerifyAuthentication(data, testKeys, 0); err != nil {
+		t.Errorf("VerifyAuthentication after relaying: %v", err)
+	}
+}
+
+func TestVerifyAuthenticationTampered(t *testing.T) {
+	auth, _ := findOption(requestMessage(t), OptionAuthentication)
+	hostname, _ := findOption(requestMessage(t), OptionHostname)
+
+	for name, off := range map[string]int{
+		"xid":               int(unsafe.Offsetof(Packet{}.TransactionID)),
+		"chaddr":            int(unsafe.Offsetof(Packet{}.ClientHardwareAddress)),
+		"option":            hostname,
+		"replay detection":  auth + 10,
+		"secret ID":         auth + authHeaderLen + 3,
+		"HMAC":              auth + authHeaderLen + 4,
+		"end of the packet": len(requestMessage(t)) - 1,
+	} {
+		data := requestMessage(t)
+		data[off] ^= 0x01
+		if _, err := VerifyAuthentication(data, testKeys, 0); err == nil {
+			t.Errorf("%s: tampered message was accepted", name)
+		}
+	}
+}
+
+func TestVerifyAuthenticationReplay(t *testing.T) {
+	data := requestMessage(t)
+
+	auth, err := VerifyAuthentication(data, testKeys, 0)
+	if err != nil {
+		t.Fatalf("VerifyAuthentication: %v", err)
+	}
+	if _, err := VerifyAuthentication(data, testKeys, auth.ReplayDetection-1); err != nil {
+		t.Errorf("VerifyAuthentication with an older replay detection value: %v", err)
+	}
+	if _, err := VerifyAuthentication(data, testKeys, auth.ReplayDetection); err != ErrAuthentication {
+		t.Errorf("replayed message: got %v, want ErrAuthentication", err)
+	}
+
+	// replay detection values keep increasing
+	if _, err := VerifyAuthentication(requestMessage(t), testKeys, auth.ReplayDetection); err != nil {
+		t.Errorf("message signed later: %v", err)
+	}
+}
+
+func TestVerifyAuthenticationKeys(t *testing.T) {
+	data := requestMessage(t)
+
+	if _, err := VerifyAuthentication(data, StaticKeyStore{7: []byte("other secret")}, 0); err != ErrAuthentication {
+		t.Errorf("wrong secret: got %v, want ErrAuthentication", err)
+	}
+	if _, err := VerifyAuthentication(data, StaticKeyStore{8: testKeys[7]}, 0); err != ErrAuthentication {
+		t.Errorf("unknown secret ID: got %v, want ErrAuthentication", err)
+	}
+
+	p := &Packet{}
+	if err := p.SetOptions(Options{OptionMessageType: MessageTypeRequest}); err != nil {
+		t.Fatalf("SetOptions: %v", err)
+	}
+	unsigned, _ := p.toBytes()
+	if _, err := VerifyAuthentication(unsigned, testKeys, 0); err != ErrAuthentication {
+		t.Errorf("unsigned message: got %v, want ErrAuthentication", err)
+	}
+}
+
+func TestVerifyAuthenticationOverloaded(t *testing.T) {
+	data := signedMessage(t, Options{
+		OptionMessageType: MessageTypeAck,
+		OptionHostname:    string(make([]byte, 290)),
+	}, minMessageSize)
+
+	// the authentication option does not fit in the options field
+	off, _ := findOption(data, OptionAuthentication)
+	file := int(unsafe.Offsetof(Packet{}.BootFilename))
+	if off < file || off >= file+len(Packet{}.BootFilename) {
+		t.Fatalf("OptionAuthentication at offset %d, want it in the file field", off)
+	}
+
+	if _, err := VerifyAuthentication(data, testKeys, 0); err != nil {
+		t.Errorf("VerifyAuthentication: %v", err)
+	}
+	data[off+authHeaderLen+4] ^= 0x01
+	if _, err := VerifyAuthentication(data, testKeys, 0); err == nil {
+		t.Errorf("tampered message was accepted")
+	}
+}
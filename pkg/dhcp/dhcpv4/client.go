@@ -1,28 +1,110 @@
 package dhcpv4
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math"
 	"math/big"
 	"net"
 	"os"
+	"sync"
 	"time"
 
+	"../internal/ifconfig"
 	"../internal/ifnet"
 )
 
-// Client is a DHCPv4 client
+// ErrNak is returned when the server replies with DHCPNAK
+var ErrNak = errors.New("Server replied with DHCPNAK")
+
+// minRetransmitWait is the minimum time to wait before retransmitting
+// a DHCPREQUEST in RENEWING or REBINDING state (RFC2131 section 4.4.5)
+const minRetransmitWait = 60 * time.Second
+
+// acquireRetryWait is the time to wait before restarting discovery after a failure
+const acquireRetryWait = 10 * time.Second
+
+// initialRetransmitDelay and maxRetransmitDelay bound the time to wait for a reply
+// before retransmitting a DHCPDISCOVER or DHCPREQUEST (RFC2131 section 4.1)
+const (
+	initialRetransmitDelay = 4 * time.Second
+	maxRetransmitDelay     = 64 * time.Second
+)
+
+// defaultMaxWriteRetries is the number of retransmissions when Client.MaxWriteRetries is not set
+const defaultMaxWriteRetries = 4
+
+// readPollInterval is how often a blocked read wakes up to check for cancellation
+const readPollInterval = 100 * time.Millisecond
+
+// Client is a DHCPv4 client.
+//
+// If DUID is set, or DUIDFile names a file to load or store a DUID in, the client
+// sends a node-specific client identifier built from IAID and the DUID (RFC4361).
+// If IAID is zero, it is derived from the name of the interface.
+//
+// If AuthKeys is set, the client asks servers to use delayed authentication (RFC3118)
+// and ignores replies which are not authenticated using one of its secrets.
+//
+// Unanswered requests are retransmitted up to MaxWriteRetries times, or 4 times if
+// it is zero, with exponential backoff. Timeout limits the duration of each exchange.
+// Replies for another transaction or hardware address are ignored, and up to
+// MaxReadRetries other replies may be rejected before giving up on a transmission.
+// Discover returns the first offer, or if OfferWindow is set, all offers received
+// within OfferWindow after sending DHCPDISCOVER.
+//
+// If ConfigureInterface is set, the address, routes and MTU of each lease are applied to
+// the interface, and removed once the lease is released, rejected by DHCPNAK or expires.
+// Interface configuration is only supported on Linux. If DNS is set, the DNS servers
+// and search domains of each lease are applied using it and reverted the same way.
+//
+// If Script is set, it is run on every change of the lease with the same reason and
+// environment variables as ISC dhclient-script, so existing hooks can be reused.
+//
+// If LeaseDir is set, the lease is stored in a file per interface in it. When a client
+// without a lease finds an unexpired lease there, it first tries to confirm it in the
+// INIT-REBOOT state, and only falls back to discovery if that fails.
+//
+// The client logs to Logger if set, and is silent otherwise.
 type Client struct {
-	Interface       *net.Interface
-	Server          net.IP
-	Options         map[uint8]interface{}
-	NoAutoClientID  bool
-	NoAutoHostname  bool
-	MaxWriteRetries uint8
-	MaxReadRetries  uint8
-	Timeout         time.Duration
+	Interface          *net.Interface
+	Server             net.IP
+	Options            map[uint8]interface{}
+	NoAutoClientID     bool
+	NoAutoHostname     bool
+	FQDN               *FQDN
+	DUID               DUID
+	DUIDFile           string
+	IAID               uint32
+	AuthKeys           KeyStore
+	MaxWriteRetries    uint8
+	MaxReadRetries     uint8
+	Timeout            time.Duration
+	OfferWindow        time.Duration
+	ConfigureInterface bool
+	DNS                DNSConfigurator
+	Script             string
+	LeaseDir           string
+	Logger             *slog.Logger
+
+	mu       sync.Mutex
+	state    dhcpState
+	xid      uint32
+	start    time.Time
+	secs     uint16 // secs of the last DHCPDISCOVER sent
+	lease    *Lease
+	stop     context.CancelFunc
+	done     chan struct{}
+	ifconfig *ifconfig.Config
+
+	// secret ID and last replay detection value of the server, once authenticated
+	authKeyID  uint32
+	authReplay uint64
+	authServer bool
 }
 
 func (c *Client) init() error {
@@ -34,12 +116,43 @@ func (c *Client) init() error {
 		c.Server = net.IPv4bcast
 	}
 
+	if c.MaxWriteRetries == 0 {
+		c.MaxWriteRetries = defaultMaxWriteRetries
+	}
+
+	if c.Options == nil {
+		c.Options = map[uint8]interface{}{}
+	}
+
+	if c.DUID == nil && c.DUIDFile != "" {
+		duid, err := LoadOrCreateDUID(c.DUIDFile, c.Interface)
+		if err != nil {
+			return fmt.Errorf("LoadOrCreateDUID: %v", err)
+		}
+		c.DUID = duid
+	}
+
 	if c.Options[OptionClientID] == nil && !c.NoAutoClientID {
-		c.Options[OptionClientID] = []byte{HardwareTypeEthernet, 0, 0, 0, 0, 0, 0}
-		copy(c.Options[OptionClientID].([]byte)[1:], c.Interface.HardwareAddr)
+		if c.DUID != nil {
+			if err := c.DUID.Validate(); err != nil {
+				return fmt.Errorf("DUID.Validate: %v", err)
+			}
+			if c.IAID == 0 {
+				c.IAID = InterfaceIAID(c.Interface)
+			}
+			c.Options[OptionClientID] = NodeClientID(c.IAID, c.DUID)
+		} else {
+			c.Options[OptionClientID] = []byte{HardwareTypeEthernet, 0, 0, 0, 0, 0, 0}
+			copy(c.Options[OptionClientID].([]byte)[1:], c.Interface.HardwareAddr)
+		}
 	}
 
-	if c.Options[OptionHostname] == nil && !c.NoAutoHostname {
+	if c.FQDN != nil && c.Options[OptionFQDN] == nil {
+		c.Options[OptionFQDN] = c.FQDN
+	}
+
+	// clients sending OptionFQDN must not also send OptionHostname (RFC4702 section 3)
+	if c.Options[OptionHostname] == nil && c.Options[OptionFQDN] == nil && !c.NoAutoHostname {
 		hostname, _ := os.Hostname()
 		c.Options[OptionHostname] = hostname
 	}
@@ -47,89 +160,824 @@ func (c *Client) init() error {
 	return nil
 }
 
-// Discover broadcasts a single DHCPDISCOVER request and returns DHCPOFFER replies
-func (c *Client) Discover() ([]*Packet, error) {
-	if err := c.init(); err != nil {
-		return nil, fmt.Errorf("Client.init: %v", err)
-	}
+// logger returns the logger of the client
+func (c *Client) logger() *slog.Logger {
+	return orDiscard(c.Logger)
+}
 
+func newTransactionID() (uint32, error) {
 	xid, err := rand.Int(rand.Reader, big.NewInt(math.MaxUint32))
 	if err != nil {
-		return nil, fmt.Errorf("rand.Int: %v", err)
+		return 0, fmt.Errorf("rand.Int: %v", err)
 	}
+	return uint32(xid.Uint64()), nil
+}
 
+// newPacket creates a request packet for the current transaction
+func (c *Client) newPacket() *Packet {
 	p := &Packet{
 		Operation:      OpRequest,
 		HardwareType:   HardwareTypeEthernet,
 		HardwareLength: uint8(len(c.Interface.HardwareAddr)),
-		TransactionID:  uint32(xid.Uint64()),
+		TransactionID:  c.xid,
 		Flags:          flagBroadcast,
 	}
 	copy(p.ClientHardwareAddress[:], c.Interface.HardwareAddr)
-	c.Options[OptionMessageType] = MessageTypeDiscover
-	p.SetOptions(c.Options)
+	return p
+}
 
-	srcIP, err := findSourceIPv4(c.Interface)
-	if err != nil {
-		return nil, fmt.Errorf("findSourceIPv4: %v", err)
+// options returns a copy of the client options with the given message type set
+func (c *Client) options(msgType uint8) Options {
+	opts := Options{}
+	for code, val := range c.Options {
+		opts[code] = val
 	}
+	opts[OptionMessageType] = msgType
+	return opts
+}
 
-	fmt.Printf("[debug] Starting DHCP client on interface %s with IP %s\n", c.Interface.HardwareAddr.String(), srcIP)
-
-	ln, err := ifnet.ListenUDP("udp4", &net.UDPAddr{
+// listen opens a connection on the client port. If the interface does not have an
+// IPv4 address yet, a raw socket is used to send and receive packets instead.
+func (c *Client) listen() (ifnet.PacketConn, error) {
+	laddr := &net.UDPAddr{
 		IP:   net.IPv4zero,
 		Port: portClient,
-	}, c.Interface)
+	}
+
+	if _, err := findSourceIPv4(c.Interface); err != nil {
+		c.logger().Debug("Using raw socket", "interface", c.Interface.Name, "reason", err)
+		ln, err := ifnet.ListenRawUDP(laddr, c.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("ifnet.ListenRawUDP: %v", err)
+		}
+		return ln, nil
+	}
+
+	ln, err := ifnet.ListenUDP("udp4", laddr, c.Interface)
 	if err != nil {
 		return nil, fmt.Errorf("ifnet.ListenUDP: %v", err)
 	}
+	return ln, nil
+}
 
-	bytes, err := p.toBytes()
+// transact sends a packet to dst and reads replies until max replies have been
+// accepted, the read retries are exhausted or the retransmission delay has passed.
+// If window is not zero, replies are read for at most window after each transmission.
+// Without replies, the packet is retransmitted up to c.MaxWriteRetries times with the
+// same transaction ID, until c.Timeout has passed. If accept is nil, no replies are read.
+// If ctx is done before that, ctx.Err() is returned.
+func (c *Client) transact(ctx context.Context, p *Packet, dst net.IP, accept func(*Packet) bool, max int, window time.Duration) ([]*Packet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ln, err := c.listen()
 	if err != nil {
-		return nil, fmt.Errorf("packet.toBytes: %v", err)
+		return nil, err
 	}
+	defer ln.Close()
 
-	var tries uint8
+	deadline, _ := ctx.Deadline()
+	if c.Timeout > 0 {
+		if t := time.Now().Add(c.Timeout); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
 
-	fmt.Printf("[debug] Broadcasting %d bytes: %x\n", len(bytes), bytes)
-	n, err := ln.WriteToUDP(bytes, &net.UDPAddr{
-		IP:   c.Server,
-		Port: portServer,
-	})
+	data := make([]byte, dhcpMaxPacketSize)
+	for attempt := 0; ; attempt++ {
+		if err := c.send(ln, p, dst); err != nil {
+			return nil, err
+		}
+		if accept == nil {
+			return nil, nil
+		}
+
+		delay := retransmitDelay(attempt)
+		if window > 0 && window < delay {
+			delay = window
+		}
+		wait := time.Now().Add(delay)
+		if !deadline.IsZero() && deadline.Before(wait) {
+			wait = deadline
+		}
+		responses, err := c.receive(ctx, ln, data, p, wait, accept, max)
+		if err != nil || len(responses) > 0 {
+			return responses, err
+		}
+		if attempt >= int(c.MaxWriteRetries) || (!deadline.IsZero() && !time.Now().Before(deadline)) {
+			return responses, nil
+		}
+		c.logger().Debug("No reply, retransmitting", append(packetAttrs(p), "dst", dst, "attempt", attempt+1)...)
+	}
+}
+
+// send updates the secs field of a packet and sends it to dst
+func (c *Client) send(ln ifnet.PacketConn, p *Packet, dst net.IP) error {
+	// a DHCPREQUEST selecting an offer repeats the secs of the DHCPDISCOVER
+	// (RFC2131 section 4.4.1), so only the secs of other messages are updated
+	if c.getState() != stateRequesting {
+		secs := time.Since(c.start) / time.Second
+		if secs > math.MaxUint16 {
+			secs = math.MaxUint16
+		}
+		p.Seconds = uint16(secs)
+		if t, _ := p.MessageType(); t == MessageTypeDiscover {
+			c.secs = p.Seconds
+		}
+	}
+
+	if err := c.authenticate(p); err != nil {
+		return err
+	}
+
+	bytes, err := p.toBytes()
 	if err != nil {
-		return nil, fmt.Errorf("ifnet.UDPConn.WriteToUDP: %v", err)
+		return fmt.Errorf("packet.toBytes: %v", err)
 	}
-	fmt.Printf("[debug] Broadcasted %d bytes\n", n)
 
-	data := make([]byte, dhcpMaxPacketSize)
+	c.logger().Debug("Sending packet", append(packetAttrs(p), "dst", dst, "len", len(bytes), "data", hexData(bytes))...)
+	if _, err := ln.WriteToUDP(bytes, &net.UDPAddr{
+		IP:   dst,
+		Port: portServer,
+	}); err != nil {
+		return fmt.Errorf("ifnet.PacketConn.WriteToUDP: %v", err)
+	}
+
+	return nil
+}
+
+// receive reads replies to req until max replies have been accepted, the read retries
+// are exhausted or deadline has passed. Packets which are not a reply to req are
+// dropped without counting as a read retry.
+func (c *Client) receive(ctx context.Context, ln ifnet.PacketConn, data []byte, req *Packet, deadline time.Time, accept func(*Packet) bool, max int) ([]*Packet, error) {
 	responses := []*Packet{}
 
-	for tries = 0; tries < 1+c.MaxReadRetries; tries++ {
-		if tries > 0 {
-			// clear buffer
-			for i := 0; i < n; i++ {
-				data[i] = 0
-			}
+	var tries uint8
+	for tries < 1+c.MaxReadRetries {
+		// clear buffer
+		for i := range data {
+			data[i] = 0
 		}
 
 		// read packet
-		n, src, err := ln.ReadFromUDP(data)
+		n, src, err := c.read(ctx, ln, data, deadline)
+		if err == os.ErrDeadlineExceeded && ctx.Err() == nil {
+			break
+		}
+		if err != nil && err == ctx.Err() {
+			return nil, err
+		}
 		if err != nil {
-			return nil, fmt.Errorf("ifnet.UDPConn.ReadFromUDP: %v", err)
+			return nil, fmt.Errorf("ifnet.PacketConn.ReadFromUDP: %v", err)
 		}
 		if n == 0 {
-			fmt.Printf("[debug] Received empty packet from %s\n", src)
+			c.logger().Debug("Received empty packet", "src", src)
+			tries++
 			continue
 		}
-		fmt.Printf("[debug] Received %d bytes from %s: %x\n", n, src, data[:n])
 
 		// parse packet
 		resp, err := parsePacket(data)
 		if err != nil {
 			return nil, fmt.Errorf("parsePacket: %v", err)
 		}
+		attrs := append(packetAttrs(resp), "src", src)
+		c.logger().Debug("Received packet", append(attrs, "len", n, "data", hexData(data[:n]))...)
+		if !isReply(req, resp) {
+			c.logger().Debug("Ignoring packet for another transaction", attrs...)
+			continue
+		}
+		if err := c.verify(data[:n]); err != nil {
+			c.logger().Warn("Ignoring unauthenticated packet", append(attrs, "error", err)...)
+			tries++
+			continue
+		}
+		if !accept(resp) {
+			tries++
+			continue
+		}
 		responses = append(responses, resp)
+		if max > 0 && len(responses) >= max {
+			break
+		}
 	}
 
 	return responses, nil
 }
+
+// isReply returns true if resp is a reply to req, with the same transaction ID
+// and client hardware address
+func isReply(req, resp *Packet) bool {
+	if resp.Operation != OpReply || resp.TransactionID != req.TransactionID {
+		return false
+	}
+	if resp.HardwareType != req.HardwareType || resp.HardwareLength != req.HardwareLength {
+		return false
+	}
+	n := int(req.HardwareLength)
+	if n > len(req.ClientHardwareAddress) {
+		n = len(req.ClientHardwareAddress)
+	}
+	return bytes.Equal(resp.ClientHardwareAddress[:n], req.ClientHardwareAddress[:n])
+}
+
+// retransmitDelay returns the time to wait for a reply before retransmitting: 4 seconds,
+// doubled for every retransmission up to 64 seconds and randomized by up to one second
+// in either direction (RFC2131 section 4.1)
+func retransmitDelay(attempt int) time.Duration {
+	d := maxRetransmitDelay
+	if attempt < 4 {
+		d = initialRetransmitDelay << uint(attempt)
+	}
+
+	jitter, err := rand.Int(rand.Reader, big.NewInt(int64(2*time.Second/time.Millisecond)+1))
+	if err != nil {
+		return d
+	}
+	return d + time.Duration(jitter.Int64())*time.Millisecond - time.Second
+}
+
+// read reads a packet until deadline, waking up regularly to return ctx.Err() promptly
+// once ctx is done. Reads which reach the deadline return os.ErrDeadlineExceeded.
+func (c *Client) read(ctx context.Context, ln ifnet.PacketConn, b []byte, deadline time.Time) (int, *net.UDPAddr, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, nil, err
+		}
+
+		wake := time.Now().Add(readPollInterval)
+		if !deadline.IsZero() && deadline.Before(wake) {
+			wake = deadline
+		}
+		if err := ln.SetReadDeadline(wake); err != nil {
+			return 0, nil, fmt.Errorf("ifnet.PacketConn.SetReadDeadline: %v", err)
+		}
+
+		n, src, err := ln.ReadFromUDP(b)
+		if err == os.ErrDeadlineExceeded && (deadline.IsZero() || time.Now().Before(deadline)) {
+			continue
+		}
+		if err == os.ErrDeadlineExceeded && ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		return n, src, err
+	}
+}
+
+// authenticate adds OptionAuthentication to a packet if the client uses authentication.
+// Until a server has authenticated itself, the client only requests authentication.
+func (c *Client) authenticate(p *Packet) error {
+	if c.AuthKeys == nil {
+		return nil
+	}
+
+	if t, _ := p.MessageType(); t == MessageTypeDiscover || !c.authServer {
+		if err := p.RequestAuthentication(); err != nil {
+			return fmt.Errorf("Packet.RequestAuthentication: %v", err)
+		}
+		return nil
+	}
+
+	key, err := c.AuthKeys.Key(c.authKeyID)
+	if err != nil {
+		return fmt.Errorf("KeyStore.Key: %v", err)
+	}
+	if key == nil {
+		return fmt.Errorf("Unknown secret ID %d", c.authKeyID)
+	}
+	if err := p.Sign(c.authKeyID, key); err != nil {
+		return fmt.Errorf("Packet.Sign: %v", err)
+	}
+	return nil
+}
+
+// verify checks the authentication of a raw reply if the client uses authentication
+func (c *Client) verify(data []byte) error {
+	if c.AuthKeys == nil {
+		return nil
+	}
+
+	// offers from different servers are verified independently, Request
+	// continues with the replay detection value of the selected offer
+	if c.getState() == stateSelecting {
+		_, err := VerifyAuthentication(data, c.AuthKeys, 0)
+		return err
+	}
+
+	auth, err := VerifyAuthentication(data, c.AuthKeys, c.authReplay)
+	if err != nil {
+		return err
+	}
+	c.authKeyID, _ = auth.SecretID()
+	c.authReplay = auth.ReplayDetection
+	c.authServer = true
+	return nil
+}
+
+// Discover broadcasts DHCPDISCOVER and returns the first DHCPOFFER reply, or all
+// replies received within c.OfferWindow if it is set
+func (c *Client) Discover(ctx context.Context) ([]*Packet, error) {
+	if err := c.init(); err != nil {
+		return nil, fmt.Errorf("Client.init: %v", err)
+	}
+
+	xid, err := newTransactionID()
+	if err != nil {
+		return nil, fmt.Errorf("newTransactionID: %v", err)
+	}
+	c.xid = xid
+	c.start = time.Now()
+	c.setState(stateInit)
+	c.authServer = false
+
+	p := c.newPacket()
+	if err := p.SetOptions(c.options(MessageTypeDiscover)); err != nil {
+		return nil, fmt.Errorf("Packet.SetOptions: %v", err)
+	}
+
+	c.logger().Debug("Discovering servers", append(packetAttrs(p), "interface", c.Interface.Name)...)
+
+	max := 1
+	if c.OfferWindow > 0 {
+		max = 0
+	}
+
+	c.setState(stateSelecting)
+	return c.transact(ctx, p, c.Server, func(resp *Packet) bool {
+		t, _ := resp.MessageType()
+		return t == MessageTypeOffer
+	}, max, c.OfferWindow)
+}
+
+// Request sends a DHCPREQUEST for the address in a DHCPOFFER received from Discover,
+// and returns the resulting lease once the server replies with DHCPACK
+func (c *Client) Request(ctx context.Context, offer *Packet) (*Lease, error) {
+	if err := c.init(); err != nil {
+		return nil, fmt.Errorf("Client.init: %v", err)
+	}
+	if c.getState() != stateSelecting {
+		return nil, errors.New("Request called outside of SELECTING state")
+	}
+
+	serverID, err := offer.ServerID()
+	if err != nil {
+		return nil, fmt.Errorf("Packet.ServerID: %v", err)
+	}
+
+	// continue with the secret and replay detection value of the selected server
+	if c.AuthKeys != nil {
+		auth, err := offer.Authentication()
+		if err != nil {
+			return nil, fmt.Errorf("Packet.Authentication: %v", err)
+		}
+		c.authKeyID, _ = auth.SecretID()
+		c.authReplay = auth.ReplayDetection
+		c.authServer = true
+	}
+
+	opts := c.options(MessageTypeRequest)
+	opts[OptionRequestedIPAddr] = offer.YourIP
+	opts[OptionServerID] = toArray4(serverID)
+
+	p := c.newPacket()
+	p.Seconds = c.secs
+	if err := p.SetOptions(opts); err != nil {
+		return nil, fmt.Errorf("Packet.SetOptions: %v", err)
+	}
+
+	c.setState(stateRequesting)
+	return c.requestLease(ctx, p, c.Server)
+}
+
+// requestLease sends a DHCPREQUEST and waits for DHCPACK or DHCPNAK
+func (c *Client) requestLease(ctx context.Context, p *Packet, dst net.IP) (*Lease, error) {
+	state, prev := c.getState(), c.Lease()
+	start := time.Now()
+	replies, err := c.transact(ctx, p, dst, func(resp *Packet) bool {
+		t, _ := resp.MessageType()
+		return t == MessageTypeAck || t == MessageTypeNak
+	}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) == 0 {
+		return nil, errors.New("No reply to DHCPREQUEST")
+	}
+
+	if t, _ := replies[0].MessageType(); t == MessageTypeNak {
+		c.logger().Info("Server declined request", packetAttrs(replies[0])...)
+		c.setLease(stateInit, nil)
+		if err := c.configure(nil); err != nil {
+			c.logger().Warn("Failed to deconfigure interface", "interface", c.Interface.Name, "error", err)
+		}
+		if err := c.removeLease(); err != nil {
+			c.logger().Warn("Failed to remove lease file", "path", c.leaseFile(), "error", err)
+		}
+		if prev != nil {
+			c.runScript(ScriptReasonExpire, prev, nil)
+		}
+		return nil, ErrNak
+	}
+
+	lease, err := newLease(replies[0], start)
+	if err != nil {
+		return nil, fmt.Errorf("newLease: %v", err)
+	}
+	c.setLease(stateBound, lease)
+	c.logger().Info("Lease bound", append(packetAttrs(replies[0]), "ip", lease.IP, "server", lease.ServerID, "lease_time", lease.LeaseTime)...)
+	if err := c.saveLease(lease); err != nil {
+		c.logger().Warn("Failed to save lease file", "path", c.leaseFile(), "error", err)
+	}
+
+	// the lease is kept if the interface cannot be configured, so it is retried on renewal
+	err = c.configure(lease)
+	c.runScript(scriptReason(state), prev, lease)
+	if err != nil {
+		return lease, fmt.Errorf("Client.configure: %v", err)
+	}
+	return lease, nil
+}
+
+// configure applies a lease to the interface if c.ConfigureInterface is set, and
+// to the DNS configuration if c.DNS is set. If lease is nil, the previously
+// applied configuration is removed.
+func (c *Client) configure(lease *Lease) error {
+	if c.ConfigureInterface {
+		if err := c.configureInterface(lease); err != nil {
+			return err
+		}
+	}
+
+	if c.DNS != nil && lease != nil {
+		if err := c.DNS.Apply(c.Interface, lease); err != nil {
+			return fmt.Errorf("DNSConfigurator.Apply: %v", err)
+		}
+	}
+	if c.DNS != nil && lease == nil {
+		if err := c.DNS.Revert(c.Interface); err != nil {
+			return fmt.Errorf("DNSConfigurator.Revert: %v", err)
+		}
+	}
+	return nil
+}
+
+// configureInterface applies a lease to the interface, updating the previously applied
+// lease by removing its address if it differs, or its routes and MTU which the lease
+// no longer contains. If lease is nil, the previously applied lease is removed.
+func (c *Client) configureInterface(lease *Lease) error {
+	var cfg *ifconfig.Config
+	if lease != nil {
+		cfg = &ifconfig.Config{
+			IP:       lease.IP,
+			Mask:     lease.SubnetMask,
+			Lifetime: time.Until(lease.Expiry()),
+		}
+		if cfg.Lifetime < time.Second {
+			cfg.Lifetime = time.Second
+		}
+		for _, r := range lease.Routes {
+			cfg.Routes = append(cfg.Routes, ifconfig.Route{Dest: r.Dest, Gateway: r.Router})
+		}
+		if mtu, err := lease.Options.InterfaceMTU(); err == nil {
+			cfg.MTU = int(mtu)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.ifconfig != nil && cfg != nil:
+		if err := ifconfig.Update(c.Interface, c.ifconfig, cfg); err != nil {
+			return fmt.Errorf("ifconfig.Update: %v", err)
+		}
+	case c.ifconfig != nil:
+		if err := ifconfig.Remove(c.Interface, c.ifconfig); err != nil {
+			return fmt.Errorf("ifconfig.Remove: %v", err)
+		}
+	case cfg != nil:
+		if err := ifconfig.Apply(c.Interface, cfg); err != nil {
+			return fmt.Errorf("ifconfig.Apply: %v", err)
+		}
+	}
+	c.ifconfig = cfg
+	return nil
+}
+
+// getState returns the current client state
+func (c *Client) getState() dhcpState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// setState updates the current client state
+func (c *Client) setState(state dhcpState) {
+	c.mu.Lock()
+	c.state = state
+	c.mu.Unlock()
+}
+
+// setLease atomically updates the client state and current lease
+func (c *Client) setLease(state dhcpState, lease *Lease) {
+	c.mu.Lock()
+	c.state = state
+	c.lease = lease
+	c.mu.Unlock()
+}
+
+// extend sends a DHCPREQUEST to extend the current lease, either unicast to
+// the leasing server (RENEWING) or broadcast to all servers (REBINDING)
+func (c *Client) extend(ctx context.Context, state dhcpState) (*Lease, error) {
+	if err := c.init(); err != nil {
+		return nil, fmt.Errorf("Client.init: %v", err)
+	}
+
+	lease := c.Lease()
+	if lease == nil {
+		return nil, errors.New("No lease to extend")
+	}
+
+	xid, err := newTransactionID()
+	if err != nil {
+		return nil, fmt.Errorf("newTransactionID: %v", err)
+	}
+	c.xid = xid
+	c.start = time.Now()
+
+	dst := net.IPv4bcast
+	if state == stateRenewing && lease.ServerID != nil {
+		dst = lease.ServerID
+	}
+
+	p := c.newPacket()
+	p.Flags = 0
+	copy(p.ClientIP[:], lease.IP.To4())
+	if err := p.SetOptions(c.options(MessageTypeRequest)); err != nil {
+		return nil, fmt.Errorf("Packet.SetOptions: %v", err)
+	}
+
+	c.setLease(state, lease)
+	return c.requestLease(ctx, p, dst)
+}
+
+// Renew attempts to extend the current lease by unicasting DHCPREQUEST to the leasing server
+func (c *Client) Renew(ctx context.Context) (*Lease, error) {
+	return c.extend(ctx, stateRenewing)
+}
+
+// Rebind attempts to extend the current lease by broadcasting DHCPREQUEST to any server
+func (c *Client) Rebind(ctx context.Context) (*Lease, error) {
+	return c.extend(ctx, stateRebinding)
+}
+
+// Acquire obtains a new lease by performing the DHCPDISCOVER, DHCPOFFER,
+// DHCPREQUEST, DHCPACK exchange described in RFC2131 section 3.1. If the lease
+// is bound but cannot be applied to the interface, it is returned with the error.
+func (c *Client) Acquire(ctx context.Context) (*Lease, error) {
+	lease, err := c.acquire(ctx)
+	if err != nil && ctx.Err() == nil && c.Lease() == nil {
+		c.runScript(ScriptReasonFail, nil, nil)
+	}
+	return lease, err
+}
+
+func (c *Client) acquire(ctx context.Context) (*Lease, error) {
+	if err := c.init(); err != nil {
+		return nil, fmt.Errorf("Client.init: %v", err)
+	}
+
+	prev, err := c.loadLease()
+	if err != nil {
+		c.logger().Warn("Failed to load lease file", "path", c.leaseFile(), "error", err)
+	}
+	if prev != nil {
+		lease, err := c.reboot(ctx, prev)
+		if err == nil || c.Lease() != nil {
+			return lease, err
+		}
+		if err == ctx.Err() {
+			return nil, err
+		}
+		c.logger().Info("Failed to confirm previous lease, discovering servers", "ip", prev.IP, "error", err)
+	}
+
+	offers, err := c.Discover(ctx)
+	if err != nil && err == ctx.Err() {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Client.Discover: %v", err)
+	}
+	if len(offers) == 0 {
+		c.setLease(stateInit, nil)
+		return nil, errors.New("No DHCPOFFER received")
+	}
+
+	return c.Request(ctx, offers[0])
+}
+
+// reboot tries to confirm a lease from a previous run by broadcasting a DHCPREQUEST
+// for its address in the INIT-REBOOT state (RFC2131 section 3.2). If the server
+// authenticated its replies, the request is signed using the secret and replay
+// detection value restored by loadLease (RFC3118).
+func (c *Client) reboot(ctx context.Context, prev *Lease) (*Lease, error) {
+	xid, err := newTransactionID()
+	if err != nil {
+		return nil, fmt.Errorf("newTransactionID: %v", err)
+	}
+	c.xid = xid
+	c.start = time.Now()
+	c.setState(stateInitReboot)
+
+	// the server identifier must not be sent in INIT-REBOOT state (RFC2131 section 4.3.2)
+	opts := c.options(MessageTypeRequest)
+	opts[OptionRequestedIPAddr] = toArray4(prev.IP)
+
+	p := c.newPacket()
+	if err := p.SetOptions(opts); err != nil {
+		return nil, fmt.Errorf("Packet.SetOptions: %v", err)
+	}
+
+	c.logger().Debug("Confirming previous lease", append(packetAttrs(p), "ip", prev.IP)...)
+
+	c.setState(stateRebooting)
+	return c.requestLease(ctx, p, c.Server)
+}
+
+// Release relinquishes the current lease by unicasting DHCPRELEASE to the server
+func (c *Client) Release(ctx context.Context) error {
+	if err := c.init(); err != nil {
+		return fmt.Errorf("Client.init: %v", err)
+	}
+	lease := c.Lease()
+	if lease == nil {
+		return errors.New("No lease to release")
+	}
+
+	xid, err := newTransactionID()
+	if err != nil {
+		return fmt.Errorf("newTransactionID: %v", err)
+	}
+	c.xid = xid
+	c.start = time.Now()
+
+	dst := lease.ServerID
+	if dst == nil {
+		dst = c.Server
+	}
+
+	opts := c.options(MessageTypeRelease)
+	delete(opts, OptionParameterList)
+	delete(opts, OptionHostname)
+	delete(opts, OptionFQDN)
+	opts[OptionServerID] = toArray4(dst)
+
+	p := c.newPacket()
+	p.Flags = 0
+	copy(p.ClientIP[:], lease.IP.To4())
+	if err := p.SetOptions(opts); err != nil {
+		return fmt.Errorf("Packet.SetOptions: %v", err)
+	}
+
+	if _, err := c.transact(ctx, p, dst, nil, 0, 0); err != nil {
+		return err
+	}
+
+	c.setLease(stateInit, nil)
+	if err := c.configure(nil); err != nil {
+		return fmt.Errorf("Client.configure: %v", err)
+	}
+	if err := c.removeLease(); err != nil {
+		return fmt.Errorf("Client.removeLease: %v", err)
+	}
+	c.runScript(ScriptReasonRelease, lease, nil)
+	return nil
+}
+
+// Lease returns the current lease, or nil if the client is not bound
+func (c *Client) Lease() *Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lease
+}
+
+// Start runs the client lifecycle in the background. The client obtains a lease
+// if it does not have one, renews it at T1, rebinds it at T2 and restarts
+// discovery when it expires, until ctx is done or Stop is called.
+func (c *Client) Start(ctx context.Context) error {
+	if err := c.init(); err != nil {
+		return fmt.Errorf("Client.init: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done != nil {
+		select {
+		case <-c.done:
+		default:
+			return errors.New("Client already started")
+		}
+	}
+	ctx, c.stop = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go c.run(ctx, c.done)
+
+	return nil
+}
+
+// Stop stops the background client lifecycle started by Start and waits for it
+// to return. The current lease is kept, call Release to relinquish it.
+func (c *Client) Stop() {
+	c.mu.Lock()
+	stop, done := c.stop, c.done
+	c.stop, c.done = nil, nil
+	c.mu.Unlock()
+
+	if stop != nil {
+		stop()
+		<-done
+	}
+}
+
+func (c *Client) run(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		var wait time.Duration
+
+		lease := c.Lease()
+		now := time.Now()
+
+		switch {
+		case lease == nil:
+			if _, err := c.Acquire(ctx); err != nil && ctx.Err() == nil {
+				c.logger().Warn("Failed to acquire lease", "interface", c.Interface.Name, "error", err)
+				wait = acquireRetryWait
+			}
+
+		case now.Before(lease.RenewAt()):
+			wait = lease.RenewAt().Sub(now)
+
+		case now.Before(lease.RebindAt()):
+			// renewal gives way to rebinding at T2 (RFC2131 section 4.4.5)
+			rctx, cancel := context.WithDeadline(ctx, lease.RebindAt())
+			_, err := c.Renew(rctx)
+			cancel()
+			if err != nil && err != ErrNak && ctx.Err() == nil {
+				c.logger().Warn("Failed to renew lease", "ip", lease.IP, "error", err)
+				wait = retransmitWait(time.Until(lease.RebindAt()))
+			}
+
+		case now.Before(lease.Expiry()):
+			rctx, cancel := context.WithDeadline(ctx, lease.Expiry())
+			_, err := c.Rebind(rctx)
+			cancel()
+			if err != nil && err != ErrNak && ctx.Err() == nil {
+				c.logger().Warn("Failed to rebind lease", "ip", lease.IP, "error", err)
+				wait = retransmitWait(time.Until(lease.Expiry()))
+			}
+
+		default:
+			c.logger().Info("Lease expired", "ip", lease.IP)
+			c.setLease(stateInit, nil)
+			if err := c.configure(nil); err != nil {
+				c.logger().Warn("Failed to deconfigure interface", "interface", c.Interface.Name, "error", err)
+			}
+			if err := c.removeLease(); err != nil {
+				c.logger().Warn("Failed to remove lease file", "path", c.leaseFile(), "error", err)
+			}
+			c.runScript(ScriptReasonExpire, lease, nil)
+		}
+
+		if wait == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				continue
+			}
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// retransmitWait returns one-half of the remaining time, down to a minimum of 60 seconds,
+// but never longer than the remaining time itself
+func retransmitWait(remaining time.Duration) time.Duration {
+	if remaining < minRetransmitWait {
+		return remaining
+	}
+	if remaining/2 < minRetransmitWait {
+		return minRetransmitWait
+	}
+	return remaining / 2
+}
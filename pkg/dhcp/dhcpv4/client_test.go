@@ -0,0 +1,88 @@
+package dhcpv4
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingDNS is a DNSConfigurator which records the leases applied to the interface
+type recordingDNS struct {
+	mu       sync.Mutex
+	applied  *Lease
+	reverted bool
+}
+
+func (d *recordingDNS) Apply(lif *net.Interface, lease *Lease) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.applied, d.reverted = lease, false
+	return nil
+}
+
+func (d *recordingDNS) Revert(lif *net.Interface) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.applied, d.reverted = nil, true
+	return nil
+}
+
+func (d *recordingDNS) isReverted() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reverted
+}
+
+// waitFor polls cond until it returns true or timeout has passed
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestClientRebindsAtT2AndExpires(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("binding the client port requires root")
+	}
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("net.InterfaceByName: %v", err)
+	}
+
+	// no server answers, so the client keeps renewing until T2 and rebinding until expiry
+	dns := &recordingDNS{}
+	c := &Client{Interface: lo, DNS: dns, LeaseDir: t.TempDir()}
+	start := time.Now()
+	c.setLease(stateBound, &Lease{
+		IP:            net.IPv4(127, 0, 0, 1).To4(),
+		ServerID:      net.IPv4(127, 0, 0, 2).To4(),
+		RenewalTime:   time.Second,
+		RebindingTime: 2 * time.Second,
+		LeaseTime:     3 * time.Second,
+		Acquired:      start,
+	})
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Client.Start: %v", err)
+	}
+	defer c.Stop()
+
+	if !waitFor(1500*time.Millisecond, func() bool { return c.getState() == stateRenewing }) {
+		t.Fatalf("client is in state %d after T1, want RENEWING", c.getState())
+	}
+	if !waitFor(time.Until(start.Add(2500*time.Millisecond)), func() bool { return c.getState() == stateRebinding }) {
+		t.Fatalf("client is in state %d after T2, want REBINDING", c.getState())
+	}
+	if !waitFor(time.Until(start.Add(3500*time.Millisecond)), func() bool { return c.Lease() == nil }) {
+		t.Fatalf("client still holds the lease after it expired")
+	}
+	if !dns.isReverted() {
+		t.Errorf("DNS configuration was not reverted when the lease expired")
+	}
+}
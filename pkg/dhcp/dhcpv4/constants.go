@@ -133,6 +133,8 @@ const (
 	OptionSubnetSelection       uint8 = 118 // [RFC3011] Subnet Selection Option
 	OptionDomainSearch          uint8 = 119 // [RFC3397] DNS domain search list
 	OptionClasslessRoutes       uint8 = 121 // [RFC3442] Classless Static Route Option
+	OptionVendorClass           uint8 = 124 // [RFC3925] Vendor-Identifying Vendor Class
+	OptionVendorInfo            uint8 = 125 // [RFC3925] Vendor-Identifying Vendor-Specific Information
 
 	// Dynamic Host Configuration Protocol (DHCP) Leasequery
 	OptionClientLastTransactionTime uint8 = 91 // [RFC4388] An integer number of seconds in the past from the time the DHCPLEASEACTIVE message is sent that the client last dealt with this server about this IP address
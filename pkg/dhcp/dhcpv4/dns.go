@@ -0,0 +1,142 @@
+package dhcpv4
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxLabelLen      = 63
+	maxDomainNameLen = 255
+	maxPointerOffset = 0x3fff
+	pointerMask      = 0xc0
+)
+
+// encodeDomainSearch encodes a list of domain names as described by RFC3397,
+// using RFC1035 message compression for suffixes shared between names
+func encodeDomainSearch(names []string) ([]byte, error) {
+	if len(names) == 0 {
+		return nil, errors.New("Invalid option value")
+	}
+
+	buf := []byte{}
+	offsets := map[string]int{}
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if name == "" {
+			// the root domain
+			buf = append(buf, 0)
+			continue
+		}
+		if len(name)+2 > maxDomainNameLen {
+			return nil, fmt.Errorf("Domain name %q too long", name)
+		}
+
+		labels := strings.Split(name, ".")
+		pointer := false
+		for i, label := range labels {
+			if len(label) == 0 || len(label) > maxLabelLen {
+				return nil, fmt.Errorf("Invalid label %q in domain name %q", label, name)
+			}
+
+			suffix := strings.ToLower(strings.Join(labels[i:], "."))
+			if off, ok := offsets[suffix]; ok {
+				buf = append(buf, pointerMask|uint8(off>>8), uint8(off))
+				pointer = true
+				break
+			}
+			if len(buf) <= maxPointerOffset {
+				offsets[suffix] = len(buf)
+			}
+			buf = append(buf, uint8(len(label)))
+			buf = append(buf, label...)
+		}
+		if !pointer {
+			buf = append(buf, 0)
+		}
+	}
+	return buf, nil
+}
+
+// decodeDomainName decodes the domain name starting at off in buf and returns it
+// along with the offset of the data following it. Compression pointers must point
+// to data before any data already read for the name, which guarantees termination.
+func decodeDomainName(buf []byte, off int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	length := 0
+	limit := off
+	for pos := off; ; {
+		if pos >= len(buf) {
+			return "", 0, errors.New("Truncated domain name")
+		}
+
+		n := int(buf[pos])
+		switch n & pointerMask {
+		case 0:
+			if n == 0 {
+				if next == -1 {
+					next = pos + 1
+				}
+				return strings.Join(labels, "."), next, nil
+			}
+			if pos+1+n > len(buf) {
+				return "", 0, errors.New("Truncated domain name")
+			}
+			length += n + 1
+			if length+1 > maxDomainNameLen {
+				return "", 0, errors.New("Domain name too long")
+			}
+			labels = append(labels, string(buf[pos+1:pos+1+n]))
+			pos += 1 + n
+		case pointerMask:
+			if pos+2 > len(buf) {
+				return "", 0, errors.New("Truncated domain name")
+			}
+			ptr := (n&^pointerMask)<<8 | int(buf[pos+1])
+			if ptr >= limit {
+				return "", 0, fmt.Errorf("Invalid compression pointer %d at offset %d", ptr, pos)
+			}
+			if next == -1 {
+				next = pos + 2
+			}
+			limit = ptr
+			pos = ptr
+		default:
+			return "", 0, fmt.Errorf("Invalid label type 0x%02x at offset %d", n&pointerMask, pos)
+		}
+	}
+}
+
+// decodeDomainSearch decodes a list of domain names as described by RFC3397
+func decodeDomainSearch(v []byte) ([]string, error) {
+	if len(v) == 0 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), OptionDomainSearch)
+	}
+
+	names := []string{}
+	for off := 0; off < len(v); {
+		name, next, err := decodeDomainName(v, off)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		off = next
+	}
+	return names, nil
+}
+
+// DomainSearch decodes OptionDomainSearch
+func (o Options) DomainSearch() ([]string, error) {
+	v, err := o.Bytes(OptionDomainSearch)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDomainSearch(v)
+}
+
+// DomainSearch decodes OptionDomainSearch of the packet
+func (p *Packet) DomainSearch() ([]string, error) {
+	return p.GetOptions().DomainSearch()
+}
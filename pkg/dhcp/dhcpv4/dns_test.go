@@ -0,0 +1,72 @@
+package dhcpv4
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// the example of RFC3397 section 2
+var domainSearchExample = append(append(
+	[]byte("\x03eng\x05apple\x03com\x00"),
+	[]byte("\x09marketing")...),
+	0xc0, 0x04,
+)
+
+func TestEncodeDomainSearch(t *testing.T) {
+	buf, err := encodeDomainSearch([]string{"eng.apple.com.", "marketing.apple.com"})
+	if err != nil {
+		t.Fatalf("encodeDomainSearch: %v", err)
+	}
+	if !bytes.Equal(buf, domainSearchExample) {
+		t.Errorf("encodeDomainSearch = %q, want %q", buf, domainSearchExample)
+	}
+}
+
+func TestDecodeDomainSearch(t *testing.T) {
+	names, err := decodeDomainSearch(domainSearchExample)
+	if err != nil {
+		t.Fatalf("decodeDomainSearch: %v", err)
+	}
+	if want := []string{"eng.apple.com", "marketing.apple.com"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("decodeDomainSearch = %q, want %q", names, want)
+	}
+}
+
+func TestDecodeDomainNameRejectsPointerLoops(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		buf  []byte
+		off  int
+	}{
+		{"self", []byte{0xc0, 0x00}, 0},
+		{"forward", []byte{0xc0, 0x02, 0x00}, 0},
+		{"cycle", []byte{0x01, 'a', 0xc0, 0x00}, 0},
+		{"mutual", []byte{0x00, 0x01, 'a', 0xc0, 0x06, 0x00, 0xc0, 0x01}, 6},
+	} {
+		if got, _, err := decodeDomainName(tt.buf, tt.off); err == nil {
+			t.Errorf("%s: decodeDomainName = %q, want error", tt.name, got)
+		}
+	}
+}
+
+func TestDecodeDomainNameInvalid(t *testing.T) {
+	long := []byte{}
+	for i := 0; i < 5; i++ {
+		long = append(long, 63)
+		long = append(long, bytes.Repeat([]byte{'a'}, 63)...)
+	}
+	long = append(long, 0)
+
+	for name, buf := range map[string][]byte{
+		"truncated label":   {0x03, 'e', 'n'},
+		"missing root":      {0x03, 'e', 'n', 'g'},
+		"truncated pointer": {0x03, 'e', 'n', 'g', 0xc0},
+		"label type":        {0x40, 0x00},
+		"too long":          long,
+	} {
+		if got, _, err := decodeDomainName(buf, 0); err == nil {
+			t.Errorf("%s: decodeDomainName = %q, want error", name, got)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package dhcpv4
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"../internal/dbus"
+)
+
+// A DNSConfigurator applies the DNS servers and search domains of a lease to the host
+type DNSConfigurator interface {
+	// Apply configures the host to use the DNS configuration of the lease on the interface
+	Apply(lif *net.Interface, lease *Lease) error
+
+	// Revert removes the DNS configuration applied to the interface
+	Revert(lif *net.Interface) error
+}
+
+// searchDomains returns the search domains of a lease from OptionDomainSearch,
+// or OptionDomainName if the server did not send a search list
+func searchDomains(lease *Lease) []string {
+	if len(lease.DomainSearch) > 0 {
+		return lease.DomainSearch
+	}
+	if lease.DomainName != "" {
+		return []string{lease.DomainName}
+	}
+	return nil
+}
+
+// defaultResolvConfPath is the path of the resolver configuration when ResolvConf.Path is not set
+const defaultResolvConfPath = "/etc/resolv.conf"
+
+// maxResolvConfNameservers is the number of name servers used by the resolver (MAXNS)
+const maxResolvConfNameservers = 3
+
+// resolvConfHeader marks resolver configuration files written by ResolvConf
+const resolvConfHeader = "# Generated by go-dhcp"
+
+// ResolvConf is a DNSConfigurator which writes the resolver configuration file.
+// The original file is kept in BackupPath, or Path with a ".dhcp-backup" suffix,
+// and restored by Revert.
+type ResolvConf struct {
+	Path       string
+	BackupPath string
+}
+
+func (r *ResolvConf) paths() (string, string) {
+	path := r.Path
+	if path == "" {
+		path = defaultResolvConfPath
+	}
+	backup := r.BackupPath
+	if backup == "" {
+		backup = path + ".dhcp-backup"
+	}
+	return path, backup
+}
+
+// Apply implements DNSConfigurator. The file is replaced atomically, and the original
+// file is moved to the backup path unless a backup already exists.
+func (r *ResolvConf) Apply(lif *net.Interface, lease *Lease) error {
+	path, backup := r.paths()
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s for interface %s\n", resolvConfHeader, lif.Name)
+	if domains := searchDomains(lease); len(domains) > 0 {
+		fmt.Fprintf(buf, "search %s\n", strings.Join(domains, " "))
+	}
+	for i, ip := range lease.DNSServers {
+		if i == maxResolvConfNameservers {
+			break
+		}
+		fmt.Fprintf(buf, "nameserver %s\n", ip)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".resolv.conf.")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("os.File.Write: %v", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("os.File.Chmod: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("os.File.Close: %v", err)
+	}
+
+	// hard linking keeps the original file in place until it is replaced, and
+	// keeps it a symbolic link if it is one, such as to the systemd-resolved stub
+	if _, err := os.Lstat(backup); os.IsNotExist(err) {
+		if err := os.Link(path, backup); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("os.Link: %v", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("os.Rename: %v", err)
+	}
+	return nil
+}
+
+// Revert implements DNSConfigurator. The original file is restored from the backup,
+// or if there was no original file, the file written by Apply is removed.
+func (r *ResolvConf) Revert(lif *net.Interface) error {
+	path, backup := r.paths()
+
+	if _, err := os.Lstat(backup); err == nil {
+		if err := os.Rename(backup, path); err != nil {
+			return fmt.Errorf("os.Rename: %v", err)
+		}
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("os.ReadFile: %v", err)
+	}
+	if bytes.HasPrefix(data, []byte(resolvConfHeader)) {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("os.Remove: %v", err)
+		}
+	}
+	return nil
+}
+
+// systemd-resolved D-Bus API
+const (
+	resolvedService   = "org.freedesktop.resolve1"
+	resolvedPath      = "/org/freedesktop/resolve1"
+	resolvedInterface = "org.freedesktop.resolve1.Manager"
+)
+
+// Resolved is a DNSConfigurator which sets the DNS servers and search domains of
+// the interface in systemd-resolved over D-Bus
+type Resolved struct{}
+
+// Apply implements DNSConfigurator
+func (Resolved) Apply(lif *net.Interface, lease *Lease) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("dbus.SystemBus: %v", err)
+	}
+	defer conn.Close()
+
+	servers := []interface{}{}
+	for _, ip := range lease.DNSServers {
+		if ip4 := ip.To4(); ip4 != nil {
+			servers = append(servers, []interface{}{int32(syscall.AF_INET), []byte(ip4)})
+		}
+	}
+	if err := conn.Call(resolvedService, resolvedPath, resolvedInterface, "SetLinkDNS", "ia(iay)", int32(lif.Index), servers); err != nil {
+		return fmt.Errorf("SetLinkDNS: %v", err)
+	}
+
+	// search domains, as opposed to routing-only domains
+	domains := []interface{}{}
+	for _, d := range searchDomains(lease) {
+		domains = append(domains, []interface{}{d, false})
+	}
+	if err := conn.Call(resolvedService, resolvedPath, resolvedInterface, "SetLinkDomains", "ia(sb)", int32(lif.Index), domains); err != nil {
+		return fmt.Errorf("SetLinkDomains: %v", err)
+	}
+	return nil
+}
+
+// Revert implements DNSConfigurator
+func (Resolved) Revert(lif *net.Interface) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("dbus.SystemBus: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Call(resolvedService, resolvedPath, resolvedInterface, "RevertLink", "i", int32(lif.Index)); err != nil {
+		return fmt.Errorf("RevertLink: %v", err)
+	}
+	return nil
+}
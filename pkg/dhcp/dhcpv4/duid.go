@@ -0,0 +1,158 @@
+package dhcpv4
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DUID types (RFC8415 section 11)
+const (
+	DUIDTypeLLT  uint16 = 1 // link-layer address plus time
+	DUIDTypeEN   uint16 = 2 // vendor-assigned unique ID based on enterprise number
+	DUIDTypeLL   uint16 = 3 // link-layer address
+	DUIDTypeUUID uint16 = 4 // UUID (RFC6355)
+)
+
+// clientIDTypeNode is the client identifier type of node-specific identifiers (RFC4361 section 6.1)
+const clientIDTypeNode uint8 = 255
+
+// maxDUIDLen is the maximum length of a DUID, excluding the type
+const maxDUIDLen = 128
+
+// duidEpoch is the base of the time in a DUID-LLT
+var duidEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// DUID is a DHCP Unique Identifier, shared with DHCPv6 to identify a host (RFC8415 section 11)
+type DUID []byte
+
+// NewDUIDLLT creates a DUID from a link-layer address and the time it was generated
+func NewDUIDLLT(hardwareType uint16, t time.Time, addr net.HardwareAddr) DUID {
+	d := binary.BigEndian.AppendUint16(nil, DUIDTypeLLT)
+	d = binary.BigEndian.AppendUint16(d, hardwareType)
+	d = binary.BigEndian.AppendUint32(d, uint32(t.Sub(duidEpoch)/time.Second))
+	return append(d, addr...)
+}
+
+// NewDUIDEN creates a DUID from an enterprise number and an identifier assigned by the vendor
+func NewDUIDEN(enterprise uint32, id []byte) DUID {
+	d := binary.BigEndian.AppendUint16(nil, DUIDTypeEN)
+	d = binary.BigEndian.AppendUint32(d, enterprise)
+	return append(d, id...)
+}
+
+// NewDUIDLL creates a DUID from a link-layer address
+func NewDUIDLL(hardwareType uint16, addr net.HardwareAddr) DUID {
+	d := binary.BigEndian.AppendUint16(nil, DUIDTypeLL)
+	d = binary.BigEndian.AppendUint16(d, hardwareType)
+	return append(d, addr...)
+}
+
+// NewDUIDUUID creates a DUID from a UUID
+func NewDUIDUUID(uuid [16]byte) DUID {
+	d := binary.BigEndian.AppendUint16(nil, DUIDTypeUUID)
+	return append(d, uuid[:]...)
+}
+
+// Type returns the type of the DUID
+func (d DUID) Type() uint16 {
+	if len(d) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(d)
+}
+
+// Validate checks the length of the DUID against its type
+func (d DUID) Validate() error {
+	if len(d) < 2 || len(d)-2 > maxDUIDLen {
+		return fmt.Errorf("Invalid DUID length %d", len(d))
+	}
+
+	var min int
+	switch d.Type() {
+	case DUIDTypeLLT:
+		min = 8
+	case DUIDTypeEN:
+		min = 6
+	case DUIDTypeLL:
+		min = 4
+	case DUIDTypeUUID:
+		if len(d) != 18 {
+			return fmt.Errorf("Invalid DUID length %d", len(d))
+		}
+	}
+	if len(d) <= min {
+		return fmt.Errorf("Invalid DUID length %d", len(d))
+	}
+	return nil
+}
+
+// String returns the DUID as colon separated hexadecimal bytes
+func (d DUID) String() string {
+	return net.HardwareAddr(d).String()
+}
+
+// ParseDUID parses a DUID from hexadecimal bytes, optionally separated by colons
+func ParseDUID(s string) (DUID, error) {
+	d, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(s), ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("hex.DecodeString: %v", err)
+	}
+	if err := DUID(d).Validate(); err != nil {
+		return nil, err
+	}
+	return DUID(d), nil
+}
+
+// LoadOrCreateDUID reads the DUID stored in a file. If the file does not exist, a
+// DUID-LLT is generated from the hardware address of the interface and stored in it,
+// so the host keeps its identity when the interface is replaced.
+func LoadOrCreateDUID(path string, lif *net.Interface) (DUID, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ParseDUID(string(data))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("os.ReadFile: %v", err)
+	}
+
+	if lif == nil || len(lif.HardwareAddr) == 0 {
+		return nil, errors.New("Interface has no hardware address")
+	}
+	d := NewDUIDLLT(uint16(HardwareTypeEthernet), time.Now(), lif.HardwareAddr)
+
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(tmp, []byte(d.String()+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("os.WriteFile: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("os.Rename: %v", err)
+	}
+	return d, nil
+}
+
+// InterfaceIAID derives an IAID from the name of an interface, which unlike its
+// hardware address remains the same when the network card is replaced
+func InterfaceIAID(lif *net.Interface) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(lif.Name))
+	return h.Sum32()
+}
+
+// NodeClientID creates a node-specific client identifier from an IAID and a DUID (RFC4361 section 6.1)
+func NodeClientID(iaid uint32, duid DUID) []byte {
+	id := []byte{clientIDTypeNode}
+	id = binary.BigEndian.AppendUint32(id, iaid)
+	return append(id, duid...)
+}
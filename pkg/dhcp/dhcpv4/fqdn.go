@@ -0,0 +1,107 @@
+package dhcpv4
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Client FQDN option flags (RFC4702 section 2.1)
+const (
+	FQDNFlagServerUpdate uint8 = 0x01 // S: the server should perform the A record update
+	FQDNFlagOverride     uint8 = 0x02 // O: the server has overridden the client's S flag
+	FQDNFlagEncoding     uint8 = 0x04 // E: the name is in canonical wire format
+	FQDNFlagNoUpdate     uint8 = 0x08 // N: the server should not perform any updates
+)
+
+// fqdnRCodeServer is the value of the deprecated RCODE fields in server replies
+const fqdnRCodeServer = 255
+
+// FQDN is the value of OptionFQDN (RFC4702). A Name ending with a dot is fully
+// qualified, otherwise it is a partial name which the server may complete.
+type FQDN struct {
+	Flags  uint8
+	RCode1 uint8
+	RCode2 uint8
+	Name   string
+}
+
+// encode encodes f, using the canonical wire format for the name if FQDNFlagEncoding is set
+func (f *FQDN) encode() ([]byte, error) {
+	if f.Flags&^(FQDNFlagServerUpdate|FQDNFlagOverride|FQDNFlagEncoding|FQDNFlagNoUpdate) != 0 {
+		return nil, errors.New("Invalid option value")
+	}
+	if f.Flags&FQDNFlagNoUpdate != 0 && f.Flags&FQDNFlagServerUpdate != 0 {
+		return nil, errors.New("Invalid option value")
+	}
+
+	buf := []byte{f.Flags, f.RCode1, f.RCode2}
+	if f.Flags&FQDNFlagEncoding == 0 {
+		return append(buf, f.Name...), nil
+	}
+
+	name := strings.TrimSuffix(f.Name, ".")
+	if len(name)+2 > maxDomainNameLen {
+		return nil, fmt.Errorf("Domain name %q too long", f.Name)
+	}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > maxLabelLen {
+				return nil, fmt.Errorf("Invalid label %q in domain name %q", label, f.Name)
+			}
+			buf = append(buf, uint8(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	if strings.HasSuffix(f.Name, ".") {
+		buf = append(buf, 0)
+	}
+	return buf, nil
+}
+
+// decodeFQDN decodes the value of OptionFQDN
+func decodeFQDN(v []byte) (*FQDN, error) {
+	if len(v) < 3 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), OptionFQDN)
+	}
+
+	f := &FQDN{Flags: v[0], RCode1: v[1], RCode2: v[2]}
+	if f.Flags&FQDNFlagEncoding == 0 {
+		f.Name = string(v[3:])
+		return f, nil
+	}
+
+	// compression is not allowed, and partial names omit the terminating root label
+	labels := []string{}
+	for idx := 3; idx < len(v); {
+		n := int(v[idx])
+		if n == 0 {
+			if idx != len(v)-1 {
+				return nil, fmt.Errorf("Data after end of name in option %d", OptionFQDN)
+			}
+			f.Name = strings.Join(labels, ".") + "."
+			return f, nil
+		}
+		if n > maxLabelLen || idx+1+n > len(v) {
+			return nil, fmt.Errorf("Invalid label at offset %d in option %d", idx, OptionFQDN)
+		}
+		labels = append(labels, string(v[idx+1:idx+1+n]))
+		idx += 1 + n
+	}
+	f.Name = strings.Join(labels, ".")
+	return f, nil
+}
+
+// FQDN decodes OptionFQDN
+func (o Options) FQDN() (*FQDN, error) {
+	v, err := o.Bytes(OptionFQDN)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFQDN(v)
+}
+
+// FQDN decodes OptionFQDN of the packet
+func (p *Packet) FQDN() (*FQDN, error) {
+	return p.GetOptions().FQDN()
+}
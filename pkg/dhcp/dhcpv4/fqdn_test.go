@@ -0,0 +1,106 @@
+package dhcpv4
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+var fqdnTests = []struct {
+	fqdn    FQDN
+	encoded []byte
+}{
+	{FQDN{Flags: FQDNFlagServerUpdate, Name: "host.example.com"}, []byte("\x01\x00\x00host.example.com")},
+	{FQDN{Flags: FQDNFlagEncoding, Name: "host.example.com."}, []byte("\x04\x00\x00\x04host\x07example\x03com\x00")},
+	{FQDN{Flags: FQDNFlagEncoding | FQDNFlagServerUpdate, Name: "host"}, []byte("\x05\x00\x00\x04host")},
+	{FQDN{Flags: FQDNFlagEncoding | FQDNFlagNoUpdate | FQDNFlagOverride, RCode1: 255, RCode2: 255}, []byte("\x0e\xff\xff")},
+}
+
+func TestFQDNEncode(t *testing.T) {
+	for _, tt := range fqdnTests {
+		buf, err := tt.fqdn.encode()
+		if err != nil {
+			t.Errorf("%q: encode: %v", tt.fqdn.Name, err)
+			continue
+		}
+		if !bytes.Equal(buf, tt.encoded) {
+			t.Errorf("%q: encode = %q, want %q", tt.fqdn.Name, buf, tt.encoded)
+		}
+	}
+}
+
+func TestDecodeFQDN(t *testing.T) {
+	for _, tt := range fqdnTests {
+		f, err := decodeFQDN(tt.encoded)
+		if err != nil {
+			t.Errorf("%q: decodeFQDN: %v", tt.encoded, err)
+			continue
+		}
+		if *f != tt.fqdn {
+			t.Errorf("%q: decodeFQDN = %+v, want %+v", tt.encoded, *f, tt.fqdn)
+		}
+	}
+}
+
+func TestFQDNEncodeInvalid(t *testing.T) {
+	for name, f := range map[string]FQDN{
+		"unknown flag":  {Flags: 0x10},
+		"N and S":       {Flags: FQDNFlagNoUpdate | FQDNFlagServerUpdate},
+		"empty label":   {Flags: FQDNFlagEncoding, Name: "host..com"},
+		"long label":    {Flags: FQDNFlagEncoding, Name: string(bytes.Repeat([]byte{'a'}, 64))},
+		"long name":     {Flags: FQDNFlagEncoding, Name: string(bytes.Repeat([]byte("a."), 128))},
+		"leading label": {Flags: FQDNFlagEncoding, Name: ".com"},
+	} {
+		if buf, err := f.encode(); err == nil {
+			t.Errorf("%s: encode = %q, want error", name, buf)
+		}
+	}
+}
+
+func TestDecodeFQDNInvalid(t *testing.T) {
+	for name, v := range map[string][]byte{
+		"short":            {0x04, 0},
+		"truncated label":  []byte("\x04\x00\x00\x04ho"),
+		"data after root":  []byte("\x04\x00\x00\x04host\x00\x01"),
+		"compressed label": []byte("\x04\x00\x00\xc0\x00"),
+	} {
+		if f, err := decodeFQDN(v); err == nil {
+			t.Errorf("%s: decodeFQDN = %+v, want error", name, f)
+		}
+	}
+}
+
+func TestUpdateDNSReplyFlags(t *testing.T) {
+	ip := net.IPv4(10, 99, 0, 2).To4()
+	ok := func(name string, ip net.IP) error { return nil }
+	fail := func(name string, ip net.IP) error { return errors.New("Update refused") }
+
+	for _, tt := range []struct {
+		name   string
+		update func(string, net.IP) error
+		flags  uint8
+		want   uint8
+	}{
+		{"update", ok, FQDNFlagServerUpdate, FQDNFlagServerUpdate},
+		{"update in wire format", ok, FQDNFlagServerUpdate | FQDNFlagEncoding, FQDNFlagServerUpdate | FQDNFlagEncoding},
+		{"client updates", ok, 0, FQDNFlagNoUpdate},
+		{"no updates", ok, FQDNFlagNoUpdate, FQDNFlagNoUpdate},
+		{"no hook", nil, FQDNFlagServerUpdate, FQDNFlagNoUpdate | FQDNFlagOverride},
+		{"hook fails", fail, FQDNFlagServerUpdate | FQDNFlagEncoding, FQDNFlagNoUpdate | FQDNFlagOverride | FQDNFlagEncoding},
+	} {
+		s := testServer(t)
+		s.DNSUpdate = tt.update
+
+		reply := s.updateDNS(&FQDN{Flags: tt.flags, Name: "host.example.com."}, ip)
+		if reply.Flags != tt.want {
+			t.Errorf("%s: reply flags = %#x, want %#x", tt.name, reply.Flags, tt.want)
+		}
+		if reply.RCode1 != fqdnRCodeServer || reply.RCode2 != fqdnRCodeServer {
+			t.Errorf("%s: reply RCODEs = %d, %d, want %d", tt.name, reply.RCode1, reply.RCode2, fqdnRCodeServer)
+		}
+		if _, err := reply.encode(); err != nil {
+			t.Errorf("%s: reply can not be encoded: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package dhcpv4
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"../internal/ifnet"
+)
+
+// A ResponseWriter is used by a Handler to reply to a request
+type ResponseWriter interface {
+	// Write sends a reply to the client, or to the relay agent the request was received from
+	Write(reply *Packet) error
+
+	// RemoteAddr returns the address the request was received from
+	RemoteAddr() *net.UDPAddr
+
+	// Interface returns the interface the request was received on
+	Interface() *net.Interface
+}
+
+// A Handler responds to a DHCP request
+type Handler interface {
+	ServeDHCP(w ResponseWriter, req *Packet)
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions as DHCP handlers
+type HandlerFunc func(w ResponseWriter, req *Packet)
+
+// ServeDHCP calls f(w, req)
+func (f HandlerFunc) ServeDHCP(w ResponseWriter, req *Packet) {
+	f(w, req)
+}
+
+// ServeMux is a DHCP request multiplexer. It dispatches each request
+// to the handler registered for the value of its OptionMessageType.
+type ServeMux struct {
+	mu       sync.RWMutex
+	handlers map[uint8]Handler
+}
+
+// NewServeMux allocates and returns a new ServeMux
+func NewServeMux() *ServeMux {
+	return &ServeMux{handlers: map[uint8]Handler{}}
+}
+
+// Handle registers the handler for the given message type,
+// replacing any handler previously registered for it
+func (mux *ServeMux) Handle(msgType uint8, handler Handler) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if mux.handlers == nil {
+		mux.handlers = map[uint8]Handler{}
+	}
+	mux.handlers[msgType] = handler
+}
+
+// HandleFunc registers the handler function for the given message type
+func (mux *ServeMux) HandleFunc(msgType uint8, handler func(w ResponseWriter, req *Packet)) {
+	mux.Handle(msgType, HandlerFunc(handler))
+}
+
+// Handler returns the handler registered for the message type of a request, or nil
+func (mux *ServeMux) Handler(req *Packet) Handler {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	t, _ := req.MessageType()
+	return mux.handlers[t]
+}
+
+// ServeDHCP dispatches the request to the handler registered for its message type.
+// Requests without a registered handler are ignored.
+func (mux *ServeMux) ServeDHCP(w ResponseWriter, req *Packet) {
+	if h := mux.Handler(req); h != nil {
+		h.ServeDHCP(w, req)
+	}
+}
+
+// NewReply creates a reply to a request with the header fields copied from the request
+// and the given message type set. Handlers may add further options using SetOptions.
+func NewReply(req *Packet, msgType uint8) (*Packet, error) {
+	reply := &Packet{
+		Operation:             OpReply,
+		HardwareType:          req.HardwareType,
+		HardwareLength:        req.HardwareLength,
+		TransactionID:         req.TransactionID,
+		Flags:                 req.Flags,
+		GatewayIP:             req.GatewayIP,
+		ClientHardwareAddress: req.ClientHardwareAddress,
+	}
+	if msgType == MessageTypeNak {
+		reply.Flags |= flagBroadcast
+	}
+
+	if err := reply.SetOptions(Options{OptionMessageType: msgType}); err != nil {
+		return nil, fmt.Errorf("Packet.SetOptions: %v", err)
+	}
+	return reply, nil
+}
+
+type responseWriter struct {
+	conn   *ifnet.UDPConn
+	lif    *net.Interface
+	req    *Packet
+	raddr  *net.UDPAddr
+	sign   func(reply *Packet) error
+	logger *slog.Logger
+}
+
+func (w *responseWriter) Write(reply *Packet) error {
+	if w.sign != nil {
+		if err := w.sign(reply); err != nil {
+			return err
+		}
+	}
+
+	bytes, err := reply.toBytes()
+	if err != nil {
+		return fmt.Errorf("packet.toBytes: %v", err)
+	}
+
+	dst := replyDestination(w.req, reply)
+	w.logger.Debug("Sending packet", append(packetAttrs(reply), "dst", dst, "len", len(bytes), "data", hexData(bytes))...)
+	if _, err := w.conn.WriteToUDP(bytes, dst); err != nil {
+		return fmt.Errorf("ifnet.UDPConn.WriteToUDP: %v", err)
+	}
+
+	return nil
+}
+
+func (w *responseWriter) RemoteAddr() *net.UDPAddr {
+	return w.raddr
+}
+
+func (w *responseWriter) Interface() *net.Interface {
+	return w.lif
+}
@@ -1,6 +1,7 @@
 package dhcpv4
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
@@ -30,3 +31,34 @@ func findSourceIPv4(i *net.Interface) (net.IP, error) {
 
 	return nil, errors.New("No IP found on interface")
 }
+
+// ipToUint32 converts an IPv4 address to its integer representation
+func ipToUint32(ip net.IP) uint32 {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(ip4)
+}
+
+// uint32ToIP converts an integer to an IPv4 address
+func uint32ToIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
+
+// toArray4 converts an IPv4 address to a [4]byte, as accepted by SetOptions
+func toArray4(ip net.IP) (v [4]byte) {
+	copy(v[:], ip.To4())
+	return
+}
+
+// hardwareAddr returns the client hardware address of a packet
+func hardwareAddr(p *Packet) net.HardwareAddr {
+	hlen := int(p.HardwareLength)
+	if hlen > len(p.ClientHardwareAddress) {
+		hlen = len(p.ClientHardwareAddress)
+	}
+	return net.HardwareAddr(append([]byte{}, p.ClientHardwareAddress[:hlen]...))
+}
@@ -0,0 +1,109 @@
+package dhcpv4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Lease is an IP address lease obtained from a DHCP server
+type Lease struct {
+	IP            net.IP
+	ServerID      net.IP
+	SubnetMask    net.IPMask
+	Routers       []net.IP
+	Routes        []ClasslessRoute
+	DNSServers    []net.IP
+	DomainName    string
+	DomainSearch  []string
+	FQDN          *FQDN
+	LeaseTime     time.Duration
+	RenewalTime   time.Duration
+	RebindingTime time.Duration
+	Acquired      time.Time
+	Options       Options
+}
+
+// newLease creates a Lease from a DHCPACK packet
+func newLease(ack *Packet, acquired time.Time) (*Lease, error) {
+	ip := net.IPv4(ack.YourIP[0], ack.YourIP[1], ack.YourIP[2], ack.YourIP[3])
+	if ip.Equal(net.IPv4zero) {
+		return nil, errors.New("No IP in DHCPACK")
+	}
+	return leaseFromOptions(ip, ack.GetOptions(), acquired)
+}
+
+// leaseFromOptions creates a Lease for an address from the options of a DHCPACK
+func leaseFromOptions(ip net.IP, opts Options, acquired time.Time) (*Lease, error) {
+	l := &Lease{
+		IP:       ip,
+		Acquired: acquired,
+		Options:  opts,
+	}
+
+	var err error
+	if l.ServerID, err = opts.ServerID(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.ServerID: %v", err)
+	}
+	if l.SubnetMask, err = opts.SubnetMask(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.SubnetMask: %v", err)
+	}
+	if l.Routers, err = opts.Routers(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.Routers: %v", err)
+	}
+	if l.Routes, err = opts.Routes(); err != nil {
+		return nil, fmt.Errorf("Options.Routes: %v", err)
+	}
+	if l.DNSServers, err = opts.DomainNameServers(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.DomainNameServers: %v", err)
+	}
+	if l.DomainName, err = opts.DomainName(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.DomainName: %v", err)
+	}
+	if l.DomainSearch, err = opts.DomainSearch(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.DomainSearch: %v", err)
+	}
+	if l.FQDN, err = opts.FQDN(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.FQDN: %v", err)
+	}
+	if l.LeaseTime, err = opts.LeaseTime(); err != nil {
+		return nil, fmt.Errorf("Options.LeaseTime: %v", err)
+	}
+	if l.RenewalTime, err = opts.RenewalTime(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.RenewalTime: %v", err)
+	}
+	if l.RebindingTime, err = opts.RebindingTime(); err != nil && err != ErrNoOption {
+		return nil, fmt.Errorf("Options.RebindingTime: %v", err)
+	}
+
+	// fall back to RFC2131 section 4.4.5 defaults for T1 and T2
+	if l.RenewalTime == 0 || l.RenewalTime > l.LeaseTime {
+		l.RenewalTime = l.LeaseTime / 2
+	}
+	if l.RebindingTime == 0 || l.RebindingTime > l.LeaseTime || l.RebindingTime < l.RenewalTime {
+		l.RebindingTime = l.LeaseTime * 7 / 8
+	}
+
+	return l, nil
+}
+
+// RenewAt returns the time at which the client should enter the RENEWING state (T1)
+func (l *Lease) RenewAt() time.Time {
+	return l.Acquired.Add(l.RenewalTime)
+}
+
+// RebindAt returns the time at which the client should enter the REBINDING state (T2)
+func (l *Lease) RebindAt() time.Time {
+	return l.Acquired.Add(l.RebindingTime)
+}
+
+// Expiry returns the time at which the lease expires
+func (l *Lease) Expiry() time.Time {
+	return l.Acquired.Add(l.LeaseTime)
+}
+
+// Expired returns true if the lease has expired at the given time
+func (l *Lease) Expired(now time.Time) bool {
+	return !now.Before(l.Expiry())
+}
@@ -0,0 +1,123 @@
+package dhcpv4
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// leaseRecord is a lease as stored in a client lease file. AuthKeyID and AuthReplay
+// are the secret ID and replay detection value of the last authenticated reply of
+// the server (RFC3118), or zero if the server did not authenticate its replies.
+type leaseRecord struct {
+	IP         net.IP
+	ServerID   net.IP
+	Acquired   time.Time
+	Expiry     time.Time
+	Options    map[uint8][]byte
+	AuthKeyID  uint32
+	AuthReplay uint64
+}
+
+// leaseFile returns the path of the lease file of the interface, or "" if c.LeaseDir is not set
+func (c *Client) leaseFile() string {
+	if c.LeaseDir == "" {
+		return ""
+	}
+	return filepath.Join(c.LeaseDir, c.Interface.Name+".lease")
+}
+
+// saveLease writes a lease to the lease file of the interface, replacing it atomically
+func (c *Client) saveLease(lease *Lease) error {
+	path := c.leaseFile()
+	if path == "" {
+		return nil
+	}
+
+	rec := &leaseRecord{
+		IP:       lease.IP,
+		ServerID: lease.ServerID,
+		Acquired: lease.Acquired,
+		Expiry:   lease.Expiry(),
+		Options:  map[uint8][]byte{},
+	}
+	if c.authServer {
+		rec.AuthKeyID = c.authKeyID
+		rec.AuthReplay = c.authReplay
+	}
+	for code, val := range lease.Options {
+		if b, ok := val.([]byte); ok {
+			rec.Options[code] = b
+		}
+	}
+	data, err := json.MarshalIndent(rec, "", "\t")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(c.LeaseDir, 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("os.WriteFile: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("os.Rename: %v", err)
+	}
+	return nil
+}
+
+// loadLease reads the lease file of the interface and restores the authentication
+// state of the server. If there is no lease file, or the lease in it has expired,
+// nil is returned.
+func (c *Client) loadLease() (*Lease, error) {
+	path := c.leaseFile()
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %v", err)
+	}
+	rec := &leaseRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %v", err)
+	}
+	if rec.IP.To4() == nil || !time.Now().Before(rec.Expiry) {
+		return nil, nil
+	}
+
+	opts := Options{}
+	for code, val := range rec.Options {
+		opts[code] = val
+	}
+	lease, err := leaseFromOptions(rec.IP, opts, rec.Acquired)
+	if err != nil {
+		return nil, fmt.Errorf("leaseFromOptions: %v", err)
+	}
+	c.authKeyID = rec.AuthKeyID
+	c.authReplay = rec.AuthReplay
+	c.authServer = rec.AuthReplay > 0
+	return lease, nil
+}
+
+// removeLease removes the lease file of the interface
+func (c *Client) removeLease() error {
+	path := c.leaseFile()
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("os.Remove: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+package dhcpv4
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// compactMinRecords is the minimum number of records in a lease file before it is compacted
+const compactMinRecords = 1000
+
+// LeaseStore stores the address bindings of a Server.
+// Implementations must be safe for concurrent use.
+type LeaseStore interface {
+	// Get returns the binding of a client, or nil if the client has no binding
+	Get(clientID []byte) (*Binding, error)
+
+	// GetByHardwareAddr returns the most recent binding of a client hardware address, or nil
+	GetByHardwareAddr(addr net.HardwareAddr) (*Binding, error)
+
+	// GetByIP returns the most recent binding of an address, or nil if it was never bound
+	GetByIP(ip net.IP) (*Binding, error)
+
+	// Allocate sets b.IP to an address between start and end which is not held by
+	// another active binding and stores b. The address previously bound to the client
+	// is preferred, followed by the requested address and then the lowest free address.
+	Allocate(b *Binding, requested, start, end net.IP, now time.Time) error
+
+	// Update creates or replaces the binding of b.ClientID
+	Update(b *Binding) error
+
+	// Release marks the binding of a client as released
+	Release(clientID []byte) error
+
+	// Expired calls fn for every binding which has expired at the given time,
+	// until fn returns false
+	Expired(now time.Time, fn func(b *Binding) bool) error
+}
+
+// MemoryLeaseStore is a LeaseStore which keeps bindings in memory only
+type MemoryLeaseStore struct {
+	mu       sync.RWMutex
+	bindings map[string]*Binding
+	byIP     map[string]string
+}
+
+// NewMemoryLeaseStore creates an empty MemoryLeaseStore
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return &MemoryLeaseStore{
+		bindings: map[string]*Binding{},
+		byIP:     map[string]string{},
+	}
+}
+
+func copyBinding(b *Binding) *Binding {
+	if b == nil {
+		return nil
+	}
+	c := *b
+	c.ClientID = append([]byte{}, b.ClientID...)
+	c.HardwareAddr = append(net.HardwareAddr{}, b.HardwareAddr...)
+	c.IP = append(net.IP{}, b.IP...)
+	return &c
+}
+
+// Get implements LeaseStore
+func (m *MemoryLeaseStore) Get(clientID []byte) (*Binding, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return copyBinding(m.bindings[string(clientID)]), nil
+}
+
+// GetByHardwareAddr implements LeaseStore
+func (m *MemoryLeaseStore) GetByHardwareAddr(addr net.HardwareAddr) (*Binding, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found *Binding
+	for _, b := range m.bindings {
+		if b.HardwareAddr.String() == addr.String() && (found == nil || b.Expiry.After(found.Expiry)) {
+			found = b
+		}
+	}
+	return copyBinding(found), nil
+}
+
+// GetByIP implements LeaseStore
+func (m *MemoryLeaseStore) GetByIP(ip net.IP) (*Binding, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return copyBinding(m.getByIP(ip)), nil
+}
+
+func (m *MemoryLeaseStore) getByIP(ip net.IP) *Binding {
+	key, ok := m.byIP[ip.To4().String()]
+	if !ok {
+		return nil
+	}
+	return m.bindings[key]
+}
+
+// free returns true if ip is not held by an active binding of another client
+func (m *MemoryLeaseStore) free(ip net.IP, clientID []byte, now time.Time) bool {
+	b := m.getByIP(ip)
+	return b == nil || !b.Active(now) || string(b.ClientID) == string(clientID)
+}
+
+// Allocate implements LeaseStore
+func (m *MemoryLeaseStore) Allocate(b *Binding, requested, start, end net.IP, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ip, err := m.allocate(b, requested, start, end, now)
+	if err != nil {
+		return err
+	}
+	b.IP = ip
+	m.update(b)
+	return nil
+}
+
+// allocate returns the address Allocate binds to b, without storing b.
+// Must be called with m.mu held.
+func (m *MemoryLeaseStore) allocate(b *Binding, requested, start, end net.IP, now time.Time) (net.IP, error) {
+	first, last := ipToUint32(start), ipToUint32(end)
+	inRange := func(ip net.IP) bool {
+		v := ipToUint32(ip)
+		return ip.To4() != nil && v >= first && v <= last
+	}
+
+	var ip net.IP
+	if prev, ok := m.bindings[string(b.ClientID)]; ok && inRange(prev.IP) && m.free(prev.IP, b.ClientID, now) {
+		ip = prev.IP
+	} else if requested != nil && inRange(requested) && m.free(requested, b.ClientID, now) {
+		ip = requested
+	} else {
+		for v := first; v <= last; v++ {
+			if candidate := uint32ToIP(v); m.free(candidate, b.ClientID, now) {
+				ip = candidate
+				break
+			}
+			if v == last { // avoid overflow at 255.255.255.255
+				break
+			}
+		}
+	}
+	if ip == nil {
+		return nil, errors.New("No free address in pool")
+	}
+	return append(net.IP{}, ip.To4()...), nil
+}
+
+// Update implements LeaseStore
+func (m *MemoryLeaseStore) Update(b *Binding) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.update(b)
+	return nil
+}
+
+func (m *MemoryLeaseStore) update(b *Binding) {
+	key := string(b.ClientID)
+	if prev, ok := m.bindings[key]; ok && m.byIP[prev.IP.To4().String()] == key {
+		delete(m.byIP, prev.IP.To4().String())
+	}
+	m.bindings[key] = copyBinding(b)
+	if len(b.IP) > 0 {
+		m.byIP[b.IP.To4().String()] = key
+	}
+}
+
+// Release implements LeaseStore
+func (m *MemoryLeaseStore) Release(clientID []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if b, ok := m.bindings[string(clientID)]; ok {
+		b.State = BindingReleased
+	}
+	return nil
+}
+
+// Expired implements LeaseStore
+func (m *MemoryLeaseStore) Expired(now time.Time, fn func(b *Binding) bool) error {
+	m.mu.RLock()
+	expired := []*Binding{}
+	for _, b := range m.bindings {
+		if b.State != BindingReserved && !now.Before(b.Expiry) {
+			expired = append(expired, copyBinding(b))
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, b := range expired {
+		if !fn(b) {
+			break
+		}
+	}
+	return nil
+}
+
+// prune removes the released and expired bindings which are not reserved
+func (m *MemoryLeaseStore) prune(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, b := range m.bindings {
+		if b.State == BindingReserved || (b.State != BindingReleased && now.Before(b.Expiry)) {
+			continue
+		}
+		if m.byIP[b.IP.To4().String()] == key {
+			delete(m.byIP, b.IP.To4().String())
+		}
+		delete(m.bindings, key)
+	}
+}
+
+// all returns copies of all bindings in the store
+func (m *MemoryLeaseStore) all() []*Binding {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bindings := make([]*Binding, 0, len(m.bindings))
+	for _, b := range m.bindings {
+		bindings = append(bindings, copyBinding(b))
+	}
+	return bindings
+}
+
+// FileLeaseStore is a LeaseStore which keeps bindings in memory and appends every
+// change to a file, one JSON encoded binding per line, so bindings survive restarts.
+// The file is compacted when opened and whenever it grows to more than twice the
+// number of bindings it describes. Compaction drops the bindings which are released
+// or expired and not reserved, so their addresses are no longer preferred for the
+// clients which held them.
+type FileLeaseStore struct {
+	*MemoryLeaseStore
+
+	path    string
+	logger  *slog.Logger
+	mu      sync.Mutex
+	f       *os.File
+	records int
+}
+
+// OpenFileLeaseStore opens or creates a lease file and loads the bindings it contains.
+// Invalid records are skipped and reported to logger, which may be nil.
+func OpenFileLeaseStore(path string, logger *slog.Logger) (*FileLeaseStore, error) {
+	s := &FileLeaseStore{
+		MemoryLeaseStore: NewMemoryLeaseStore(),
+		path:             path,
+		logger:           orDiscard(logger),
+	}
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("os.Open: %v", err)
+	}
+	if err == nil {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 4096), 1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			b := &Binding{}
+			if err := json.Unmarshal(sc.Bytes(), b); err != nil {
+				// a torn write at the end of the file is expected after a crash
+				s.logger.Warn("Skipping invalid lease record", "path", path, "line", line, "error", err)
+				continue
+			}
+			s.MemoryLeaseStore.update(b)
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("bufio.Scanner.Scan: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// compact removes released and expired bindings and rewrites the lease file with
+// a single record per remaining binding. Must be called with s.mu held.
+func (s *FileLeaseStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile: %v", err)
+	}
+
+	s.MemoryLeaseStore.prune(time.Now())
+	bindings := s.MemoryLeaseStore.all()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, b := range bindings {
+		if err := enc.Encode(b); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("json.Encoder.Encode: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("bufio.Writer.Flush: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("os.File.Sync: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("os.File.Close: %v", err)
+	}
+
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("os.Rename: %v", err)
+	}
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("os.OpenFile: %v", err)
+	}
+	s.records = len(bindings)
+
+	return nil
+}
+
+// size returns the number of bindings in the store
+func (m *MemoryLeaseStore) size() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.bindings)
+}
+
+// append writes a binding record to the lease file. Changes are written before
+// they are applied to the bindings in memory, so a failed write leaves both unchanged.
+// Must be called with s.mu held.
+func (s *FileLeaseStore) append(b *Binding) error {
+	if s.f == nil {
+		return errors.New("Lease file closed")
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+	if _, err := s.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("os.File.Write: %v", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("os.File.Sync: %v", err)
+	}
+	s.records++
+	return nil
+}
+
+// compactIfNeeded compacts the lease file once it has grown to more than twice the
+// number of bindings. The change which was just appended is already stored, so a
+// failure is only reported to the logger. Must be called with s.mu held.
+func (s *FileLeaseStore) compactIfNeeded() {
+	if s.records > compactMinRecords && s.records > 2*s.MemoryLeaseStore.size() {
+		if err := s.compact(); err != nil {
+			s.logger.Warn("Failed to compact lease file", "path", s.path, "error", err)
+		}
+	}
+}
+
+// Allocate implements LeaseStore
+func (s *FileLeaseStore) Allocate(b *Binding, requested, start, end net.IP, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the bindings in memory only change with s.mu held, so the address stays free
+	s.MemoryLeaseStore.mu.RLock()
+	ip, err := s.MemoryLeaseStore.allocate(b, requested, start, end, now)
+	s.MemoryLeaseStore.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	prev := b.IP
+	b.IP = ip
+	if err := s.append(b); err != nil {
+		b.IP = prev
+		return err
+	}
+	s.MemoryLeaseStore.Update(b)
+	s.compactIfNeeded()
+	return nil
+}
+
+// Update implements LeaseStore
+func (s *FileLeaseStore) Update(b *Binding) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(b); err != nil {
+		return err
+	}
+	s.MemoryLeaseStore.Update(b)
+	s.compactIfNeeded()
+	return nil
+}
+
+// Release implements LeaseStore
+func (s *FileLeaseStore) Release(clientID []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.MemoryLeaseStore.Get(clientID)
+	if err != nil || b == nil {
+		return err
+	}
+	b.State = BindingReleased
+	if err := s.append(b); err != nil {
+		return err
+	}
+	s.MemoryLeaseStore.Release(clientID)
+	s.compactIfNeeded()
+	return nil
+}
+
+// Close closes the lease file
+func (s *FileLeaseStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,162 @@
+package dhcpv4
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testPoolStart = net.IPv4(10, 99, 0, 10).To4()
+	testPoolEnd   = net.IPv4(10, 99, 0, 20).To4()
+)
+
+// countRecords returns the number of lines in a lease file
+func countRecords(t *testing.T, path string) int {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	defer f.Close()
+
+	n := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); n++ {
+	}
+	return n
+}
+
+func openTestLeaseStore(t *testing.T, path string) *FileLeaseStore {
+	t.Helper()
+
+	s, err := OpenFileLeaseStore(path, nil)
+	if err != nil {
+		t.Fatalf("OpenFileLeaseStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestFileLeaseStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	now := time.Now()
+
+	s := openTestLeaseStore(t, path)
+	b := &Binding{ClientID: []byte("ca"), HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, State: BindingBound, Expiry: now.Add(time.Hour)}
+	if err := s.Allocate(b, nil, testPoolStart, testPoolEnd, now); err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	b.Hostname = "a"
+	if err := s.Update(b); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := s.Update(&Binding{ClientID: []byte("cb"), IP: net.IPv4(10, 99, 0, 11).To4(), State: BindingBound, Expiry: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if n := countRecords(t, path); n != 3 {
+		t.Errorf("lease file has %d records, want 3", n)
+	}
+	s.Close()
+
+	// reloading keeps the last record of every binding and compacts the file
+	s = openTestLeaseStore(t, path)
+	got, err := s.Get([]byte("ca"))
+	if err != nil || got == nil {
+		t.Fatalf("Get = %+v, %v after reload", got, err)
+	}
+	if !got.IP.Equal(testPoolStart) || got.Hostname != "a" || got.State != BindingBound {
+		t.Errorf("binding after reload is %+v", got)
+	}
+	if got, _ := s.GetByIP(net.IPv4(10, 99, 0, 11)); got == nil || string(got.ClientID) != "cb" {
+		t.Errorf("GetByIP after reload = %+v", got)
+	}
+	if n := countRecords(t, path); n != 2 {
+		t.Errorf("compacted lease file has %d records, want 2", n)
+	}
+}
+
+func TestFileLeaseStoreCompactsReleasedAndExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	now := time.Now()
+
+	s := openTestLeaseStore(t, path)
+	for _, b := range []*Binding{
+		{ClientID: []byte("cbound"), IP: net.IPv4(10, 99, 0, 10).To4(), State: BindingBound, Expiry: now.Add(time.Hour)},
+		{ClientID: []byte("creleased"), IP: net.IPv4(10, 99, 0, 11).To4(), State: BindingBound, Expiry: now.Add(time.Hour)},
+		{ClientID: []byte("cexpired"), IP: net.IPv4(10, 99, 0, 12).To4(), State: BindingBound, Expiry: now.Add(-time.Minute)},
+		{ClientID: []byte("rreserved"), IP: net.IPv4(10, 99, 0, 13).To4(), State: BindingReserved},
+	} {
+		if err := s.Update(b); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	if err := s.Release([]byte("creleased")); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	s.Close()
+
+	s = openTestLeaseStore(t, path)
+	for id, kept := range map[string]bool{"cbound": true, "creleased": false, "cexpired": false, "rreserved": true} {
+		if b, _ := s.Get([]byte(id)); (b != nil) != kept {
+			t.Errorf("%s: binding after compaction is %+v, want kept %v", id, b, kept)
+		}
+	}
+	if n := countRecords(t, path); n != 2 {
+		t.Errorf("compacted lease file has %d records, want 2", n)
+	}
+
+	// the released address is no longer preferred for its previous client
+	b := &Binding{ClientID: []byte("cother"), State: BindingBound, Expiry: now.Add(time.Hour)}
+	if err := s.Allocate(b, net.IPv4(10, 99, 0, 11), testPoolStart, testPoolEnd, now); err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if !b.IP.Equal(net.IPv4(10, 99, 0, 11)) {
+		t.Errorf("Allocate = %s, want the released address 10.99.0.11", b.IP)
+	}
+}
+
+func TestFileLeaseStoreFailedWriteKeepsBindings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	now := time.Now()
+
+	s := openTestLeaseStore(t, path)
+	bound := &Binding{ClientID: []byte("ca"), IP: testPoolStart, State: BindingBound, Expiry: now.Add(time.Hour)}
+	if err := s.Update(bound); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// writes to the lease file fail from now on
+	ro, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	s.mu.Lock()
+	s.f.Close()
+	s.f = ro
+	s.mu.Unlock()
+
+	b := &Binding{ClientID: []byte("cb"), State: BindingBound, Expiry: now.Add(time.Hour)}
+	if err := s.Allocate(b, nil, testPoolStart, testPoolEnd, now); err == nil {
+		t.Errorf("Allocate succeeded without writing the lease file")
+	}
+	if b.IP != nil {
+		t.Errorf("failed Allocate set the address %s", b.IP)
+	}
+	if got, _ := s.Get([]byte("cb")); got != nil {
+		t.Errorf("failed Allocate stored %+v", got)
+	}
+
+	if err := s.Update(&Binding{ClientID: []byte("ca"), IP: testPoolStart, State: BindingDeclined, Expiry: now}); err == nil {
+		t.Errorf("Update succeeded without writing the lease file")
+	}
+	if err := s.Release([]byte("ca")); err == nil {
+		t.Errorf("Release succeeded without writing the lease file")
+	}
+	if got, _ := s.Get([]byte("ca")); got == nil || got.State != BindingBound || !got.Expiry.Equal(bound.Expiry) {
+		t.Errorf("failed writes changed the binding to %+v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package dhcpv4
+
+import (
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"strconv"
+)
+
+// discardLogger is used when no logger is configured, so the package is silent by default
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// messageTypeNames are the names of the message types of RFC2131
+var messageTypeNames = map[uint8]string{
+	MessageTypeDiscover: "DHCPDISCOVER",
+	MessageTypeOffer:    "DHCPOFFER",
+	MessageTypeRequest:  "DHCPREQUEST",
+	MessageTypeDecline:  "DHCPDECLINE",
+	MessageTypeAck:      "DHCPACK",
+	MessageTypeNak:      "DHCPNAK",
+	MessageTypeRelease:  "DHCPRELEASE",
+	MessageTypeInform:   "DHCPINFORM",
+}
+
+// orDiscard returns l, or a logger which discards all records if l is nil
+func orDiscard(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return discardLogger
+	}
+	return l
+}
+
+// packetAttrs returns the log attributes identifying a packet: its transaction ID,
+// client hardware address and message type
+func packetAttrs(p *Packet) []any {
+	n := int(p.HardwareLength)
+	if n > len(p.ClientHardwareAddress) {
+		n = len(p.ClientHardwareAddress)
+	}
+
+	msgType := "none"
+	if t, err := p.MessageType(); err == nil {
+		if name, ok := messageTypeNames[t]; ok {
+			msgType = name
+		} else {
+			msgType = strconv.Itoa(int(t))
+		}
+	}
+
+	return []any{
+		slog.String("xid", "0x"+strconv.FormatUint(uint64(p.TransactionID), 16)),
+		slog.String("chaddr", net.HardwareAddr(p.ClientHardwareAddress[:n]).String()),
+		slog.String("type", msgType),
+	}
+}
+
+// hexData is logged as hexadecimal, encoded only when the record is enabled
+type hexData []byte
+
+// LogValue implements slog.LogValuer
+func (d hexData) LogValue() slog.Value {
+	return slog.StringValue(hex.EncodeToString(d))
+}
@@ -0,0 +1,337 @@
+package dhcpv4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ErrNoOption is returned by typed option accessors when the option is not present
+var ErrNoOption = errors.New("Option not present")
+
+// Bytes returns the raw value of an option as returned by GetOptions
+func (o Options) Bytes(code uint8) ([]byte, error) {
+	val, ok := o[code]
+	if !ok {
+		return nil, ErrNoOption
+	}
+	v, ok := val.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("Invalid type %T for option %d", val, code)
+	}
+	return v, nil
+}
+
+// fixed returns the raw value of an option which must be exactly n bytes long
+func (o Options) fixed(code uint8, n int) ([]byte, error) {
+	v, err := o.Bytes(code)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) != n {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), code)
+	}
+	return v, nil
+}
+
+// Uint8 decodes a single byte option
+func (o Options) Uint8(code uint8) (uint8, error) {
+	v, err := o.fixed(code, 1)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+// Uint16 decodes a 16-bit integer option
+func (o Options) Uint16(code uint8) (uint16, error) {
+	v, err := o.fixed(code, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(v), nil
+}
+
+// Uint32 decodes a 32-bit integer option
+func (o Options) Uint32(code uint8) (uint32, error) {
+	v, err := o.fixed(code, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(v), nil
+}
+
+// Bool decodes a boolean (0/1) option
+func (o Options) Bool(code uint8) (bool, error) {
+	v, err := o.Uint8(code)
+	if err != nil {
+		return false, err
+	}
+	if v > 1 {
+		return false, fmt.Errorf("Invalid value %d for option %d", v, code)
+	}
+	return v == 1, nil
+}
+
+// String decodes a string option. Trailing NUL bytes are removed.
+func (o Options) String(code uint8) (string, error) {
+	v, err := o.Bytes(code)
+	if err != nil {
+		return "", err
+	}
+	for len(v) > 0 && v[len(v)-1] == 0 {
+		v = v[:len(v)-1]
+	}
+	return string(v), nil
+}
+
+// Seconds decodes a 32-bit unsigned number of seconds option
+func (o Options) Seconds(code uint8) (time.Duration, error) {
+	v, err := o.Uint32(code)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(v) * time.Second, nil
+}
+
+// IP decodes a single IPv4 address option
+func (o Options) IP(code uint8) (net.IP, error) {
+	v, err := o.fixed(code, 4)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(v[0], v[1], v[2], v[3]), nil
+}
+
+// IPs decodes a list of IPv4 addresses option
+func (o Options) IPs(code uint8) ([]net.IP, error) {
+	v, err := o.Bytes(code)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 || len(v)%4 != 0 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), code)
+	}
+	ips := make([]net.IP, 0, len(v)/4)
+	for i := 0; i < len(v); i += 4 {
+		ips = append(ips, net.IPv4(v[i], v[i+1], v[i+2], v[i+3]))
+	}
+	return ips, nil
+}
+
+// SubnetMask decodes OptionSubnetMask
+func (o Options) SubnetMask() (net.IPMask, error) {
+	v, err := o.fixed(OptionSubnetMask, 4)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4Mask(v[0], v[1], v[2], v[3]), nil
+}
+
+// TimeOffset decodes OptionTimeOffset, the offset of the client subnet from UTC
+func (o Options) TimeOffset() (time.Duration, error) {
+	v, err := o.Uint32(OptionTimeOffset)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(int32(v)) * time.Second, nil
+}
+
+// Routers decodes OptionRouters
+func (o Options) Routers() ([]net.IP, error) {
+	return o.IPs(OptionRouters)
+}
+
+// TimeServers decodes OptionTimeServers
+func (o Options) TimeServers() ([]net.IP, error) {
+	return o.IPs(OptionTimeServers)
+}
+
+// DomainNameServers decodes OptionDomainNameServers
+func (o Options) DomainNameServers() ([]net.IP, error) {
+	return o.IPs(OptionDomainNameServers)
+}
+
+// NTPServers decodes OptionNTPServers
+func (o Options) NTPServers() ([]net.IP, error) {
+	return o.IPs(OptionNTPServers)
+}
+
+// Hostname decodes OptionHostname
+func (o Options) Hostname() (string, error) {
+	return o.String(OptionHostname)
+}
+
+// DomainName decodes OptionDomainName
+func (o Options) DomainName() (string, error) {
+	return o.String(OptionDomainName)
+}
+
+// InterfaceMTU decodes OptionInterfaceMTU
+func (o Options) InterfaceMTU() (uint16, error) {
+	v, err := o.Uint16(OptionInterfaceMTU)
+	if err != nil {
+		return 0, err
+	}
+	if v < 68 {
+		return 0, fmt.Errorf("Invalid value %d for option %d", v, OptionInterfaceMTU)
+	}
+	return v, nil
+}
+
+// BroadcastAddr decodes OptionBroadcastAddr
+func (o Options) BroadcastAddr() (net.IP, error) {
+	return o.IP(OptionBroadcastAddr)
+}
+
+// RequestedIP decodes OptionRequestedIPAddr
+func (o Options) RequestedIP() (net.IP, error) {
+	return o.IP(OptionRequestedIPAddr)
+}
+
+// LeaseTime decodes OptionIPAddrLeaseTime
+func (o Options) LeaseTime() (time.Duration, error) {
+	return o.Seconds(OptionIPAddrLeaseTime)
+}
+
+// Overload decodes OptionOverload
+func (o Options) Overload() (uint8, error) {
+	v, err := o.Uint8(OptionOverload)
+	if err != nil {
+		return 0, err
+	}
+	if v == 0 || v > 3 {
+		return 0, fmt.Errorf("Invalid value %d for option %d", v, OptionOverload)
+	}
+	return v, nil
+}
+
+// MessageType decodes OptionMessageType
+func (o Options) MessageType() (uint8, error) {
+	v, err := o.Uint8(OptionMessageType)
+	if err != nil {
+		return 0, err
+	}
+	if v == 0 {
+		return 0, fmt.Errorf("Invalid value %d for option %d", v, OptionMessageType)
+	}
+	return v, nil
+}
+
+// ServerID decodes OptionServerID
+func (o Options) ServerID() (net.IP, error) {
+	return o.IP(OptionServerID)
+}
+
+// ParameterList decodes OptionParameterList
+func (o Options) ParameterList() ([]uint8, error) {
+	v, err := o.Bytes(OptionParameterList)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), OptionParameterList)
+	}
+	return v, nil
+}
+
+// Message decodes OptionMessage
+func (o Options) Message() (string, error) {
+	return o.String(OptionMessage)
+}
+
+// MaxMessageSize decodes OptionMaxMessageSize
+func (o Options) MaxMessageSize() (uint16, error) {
+	v, err := o.Uint16(OptionMaxMessageSize)
+	if err != nil {
+		return 0, err
+	}
+	if v < 576 {
+		return 0, fmt.Errorf("Invalid value %d for option %d", v, OptionMaxMessageSize)
+	}
+	return v, nil
+}
+
+// RenewalTime decodes OptionRenewalTime (T1)
+func (o Options) RenewalTime() (time.Duration, error) {
+	return o.Seconds(OptionRenewalTime)
+}
+
+// RebindingTime decodes OptionRebindingTime (T2)
+func (o Options) RebindingTime() (time.Duration, error) {
+	return o.Seconds(OptionRebindingTime)
+}
+
+// ClassID decodes OptionClassID
+func (o Options) ClassID() ([]byte, error) {
+	return o.Bytes(OptionClassID)
+}
+
+// ClientID decodes OptionClientID
+func (o Options) ClientID() ([]byte, error) {
+	v, err := o.Bytes(OptionClientID)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) < 2 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), OptionClientID)
+	}
+	return v, nil
+}
+
+// TFTPServerName decodes OptionTFTPServerName
+func (o Options) TFTPServerName() (string, error) {
+	return o.String(OptionTFTPServerName)
+}
+
+// BootFileName decodes OptionBootFileName
+func (o Options) BootFileName() (string, error) {
+	return o.String(OptionBootFileName)
+}
+
+// MessageType decodes OptionMessageType of the packet
+func (p *Packet) MessageType() (uint8, error) {
+	return p.GetOptions().MessageType()
+}
+
+// ServerID decodes OptionServerID of the packet
+func (p *Packet) ServerID() (net.IP, error) {
+	return p.GetOptions().ServerID()
+}
+
+// RequestedIP decodes OptionRequestedIPAddr of the packet
+func (p *Packet) RequestedIP() (net.IP, error) {
+	return p.GetOptions().RequestedIP()
+}
+
+// ClientID decodes OptionClientID of the packet
+func (p *Packet) ClientID() ([]byte, error) {
+	return p.GetOptions().ClientID()
+}
+
+// Hostname decodes OptionHostname of the packet
+func (p *Packet) Hostname() (string, error) {
+	return p.GetOptions().Hostname()
+}
+
+// SubnetMask decodes OptionSubnetMask of the packet
+func (p *Packet) SubnetMask() (net.IPMask, error) {
+	return p.GetOptions().SubnetMask()
+}
+
+// Routers decodes OptionRouters of the packet
+func (p *Packet) Routers() ([]net.IP, error) {
+	return p.GetOptions().Routers()
+}
+
+// DomainNameServers decodes OptionDomainNameServers of the packet
+func (p *Packet) DomainNameServers() ([]net.IP, error) {
+	return p.GetOptions().DomainNameServers()
+}
+
+// LeaseTime decodes OptionIPAddrLeaseTime of the packet
+func (p *Packet) LeaseTime() (time.Duration, error) {
+	return p.GetOptions().LeaseTime()
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sort"
 	"unsafe"
 )
 
@@ -44,26 +45,63 @@ const dhcpMaxPacketSize = unsafe.Sizeof(Packet{})
 // The Options type is a nice way of representing DHCP option codes
 type Options map[uint8]interface{}
 
-func (p *Packet) optionsLen() (end int) {
-	for idx := 0; idx < cap(p.Options)-1; idx++ { // seek to next option
+// optionsLen returns the length of the options field up to and including OptionEnd
+func (p *Packet) optionsLen() int {
+	idx := len(dhcpCookie)
+	for idx < len(p.Options) {
 		code := p.Options[idx]
-		if idx < len(dhcpCookie) && code == dhcpCookie[idx] { // skip magic cookie
-			continue
+		if code == OptionEnd { // end on first option code OptionEnd
+			return idx + 1
 		}
 		if code == OptionPad { // skip padding
+			idx++
 			continue
 		}
-		if code == OptionEnd { // end on first option code OptionEnd
-			end = idx + 1 // update end index
+		if idx+1 >= len(p.Options) {
+			break
+		}
+		idx += 2 + int(p.Options[idx+1]) // skip code, length and value
+	}
+	if idx > len(p.Options) {
+		return len(p.Options)
+	}
+	return idx
+}
+
+// parseOptions parses a buffer of options into opts,
+// stopping at OptionEnd or at the first truncated option.
+// Values of options which are already present in opts are appended to.
+func parseOptions(buf []byte, opts Options) {
+	for idx := 0; idx < len(buf); {
+		code := buf[idx]
+		if code == OptionEnd {
+			return
+		}
+		if code == OptionPad {
+			idx++
+			continue
+		}
+		if idx+1 >= len(buf) {
+			return
+		}
+		optlen := int(buf[idx+1])
+		idx += 2
+		if idx+optlen > len(buf) {
 			return
 		}
-		idx += 1 + int(p.Options[idx+1]) // increment idx by option length
-		end = idx                        // update end index
+		// RFC3396: multiple instances of the same option are concatenated
+		if prev, ok := opts[code].([]byte); ok {
+			opts[code] = append(prev, buf[idx:idx+optlen]...)
+		} else {
+			opts[code] = append([]byte{}, buf[idx:idx+optlen]...)
+		}
+		idx += optlen
 	}
-	return
 }
 
-// GetOptions parses the packet options field and returns it as an Options type
+// GetOptions parses the packet options field and returns it as an Options type.
+// If the options field contains OptionOverload, options stored in the
+// BootFilename and ServerHostname fields are parsed as well.
 func (p *Packet) GetOptions() Options {
 	opts := Options{}
 
@@ -74,17 +112,19 @@ func (p *Packet) GetOptions() Options {
 		return opts
 	}
 
-	for idx := len(dhcpCookie); idx < p.optionsLen(); idx++ {
-		code := p.Options[idx]
-		if code == OptionEnd {
-			break
-		}
-		idx++
-		optlen := int(p.Options[idx])
-		idx++
-		opts[code] = p.Options[idx : idx+optlen]
-		idx += optlen - 1
-		// fmt.Printf("[debug] Read DHCP Option code %d, length %d, value %v\n", code, optlen, opts[code])
+	parseOptions(p.Options[len(dhcpCookie):], opts)
+
+	// RFC2131 section 4.1: the options field is interpreted first,
+	// then the file field, then the sname field
+	overload, err := opts.Overload()
+	if err != nil {
+		return opts
+	}
+	if overload&overloadFile != 0 {
+		parseOptions(p.BootFilename[:], opts)
+	}
+	if overload&overloadSname != 0 {
+		parseOptions(p.ServerHostname[:], opts)
 	}
 
 	return opts
@@ -92,7 +132,106 @@ func (p *Packet) GetOptions() Options {
 
 // SetOptions clears a packet options field and fills it with the provided values.
 // Currently supports a wide variety of types for all RFC2132 options.
+//
+// Options which do not fit in the options field are stored in the BootFilename
+// and ServerHostname fields if they are empty, and OptionOverload is set accordingly.
 func (p *Packet) SetOptions(opts Options) error {
+	return p.SetOptionsMaxSize(opts, int(dhcpFixedLen)+len(p.Options))
+}
+
+// SetOptionsMaxSize works like SetOptions, but limits the options field so the
+// resulting IP datagram is at most maxSize bytes, such as the value of a
+// client's OptionMaxMessageSize. maxSize may not be less than 576.
+func (p *Packet) SetOptionsMaxSize(opts Options, maxSize int) error {
+	if maxSize < int(dhcpOptionsLenMin+dhcpFixedLen) {
+		return errors.New("Invalid maximum message size")
+	}
+	size := maxSize - int(dhcpFixedLen)
+	if size > len(p.Options) {
+		size = len(p.Options)
+	}
+
+	// encode options, with the message type first and the relay agent information last (RFC3046)
+	codes := make([]int, 0, len(opts))
+	for code := range opts {
+		if uint8(code) == OptionOverload || uint8(code) == OptionPad || uint8(code) == OptionEnd {
+			continue // set automatically
+		}
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	encoded := make([]encodedOption, 0, len(codes))
+	for _, code := range codes {
+		val, err := encodeOption(uint8(code), opts[uint8(code)])
+		if err != nil {
+			return err
+		}
+		opt := encodedOption{code: uint8(code), val: val}
+		if opt.code == OptionMessageType {
+			encoded = append([]encodedOption{opt}, encoded...)
+		} else {
+			encoded = append(encoded, opt)
+		}
+	}
+	for i, opt := range encoded {
+		if opt.code == OptionRelayAgentOptions {
+			encoded = append(append(encoded[:i:i], encoded[i+1:]...), opt)
+			break
+		}
+	}
+
+	// clear fields holding options from a previous call to SetOptions
+	if overload, err := p.GetOptions().Overload(); err == nil {
+		if overload&overloadFile != 0 {
+			p.BootFilename = [len(p.BootFilename)]byte{}
+		}
+		if overload&overloadSname != 0 {
+			p.ServerHostname = [len(p.ServerHostname)]byte{}
+		}
+	}
+
+	// lay out options in the options field, then the file and sname fields.
+	// Values longer than 255 bytes are split into multiple options as described in RFC3396.
+	fields := []*optionField{
+		{buf: p.Options[len(dhcpCookie):size]},
+	}
+	if isZero(p.BootFilename[:]) {
+		fields = append(fields, &optionField{buf: p.BootFilename[:], overload: overloadFile})
+	}
+	if isZero(p.ServerHostname[:]) {
+		fields = append(fields, &optionField{buf: p.ServerHostname[:], overload: overloadSname})
+	}
+	if fits(fields[0], encoded) {
+		fields = fields[:1]
+	} else if len(fields) > 1 {
+		// reserve room for OptionOverload in the options field
+		fields[0].buf = fields[0].buf[:len(fields[0].buf)-3]
+	}
+
+	var overload uint8
+	field := 0
+	for _, opt := range encoded {
+		val := opt.val
+		for {
+			if field == len(fields) {
+				return errors.New("Options do not fit in packet")
+			}
+			f := fields[field]
+			n := f.room(len(val), len(opt.val) > 255)
+			if n < 0 {
+				field++
+				continue
+			}
+			f.data = append(f.data, opt.code, uint8(n))
+			f.data = append(f.data, val[:n]...)
+			overload |= f.overload
+			val = val[n:]
+			if len(val) == 0 {
+				break
+			}
+		}
+	}
+
 	// clear option buffer in case of packet reuse
 	// or multiple calls to SetOptions
 	for i := range p.Options {
@@ -101,406 +240,591 @@ func (p *Packet) SetOptions(opts Options) error {
 
 	// copy DHCP cookie to option buffer
 	copy(p.Options[:4], dhcpCookie[:4])
-	idx := 4
-
-	for code, _val := range opts {
-		p.Options[idx] = code
-		idx++
-
-		switch code {
-		// uint32 / [1-4]uint32 / [(1+n*4)-32]byte / [1-4][4]byte
-		case OptionRouters, OptionTimeServers, OptionNameServers,
-			OptionDomainNameServers, OptionLogServers, OptionCookieServers,
-			OptionLPRServers, OptionImpressServers, OptionResourceLocationServers:
-			switch _val.(type) {
-			case uint32: // uint32
-				val := _val.(uint32)
-				p.Options[idx] = 4
-				idx++
-				binary.BigEndian.PutUint32(p.Options[idx:idx+4], val)
-				idx += 4
-			case []uint32: // [1-4]uint32
-				val := _val.([]uint32)
-				if len(val) == 0 || len(val) > 4 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val) * 4)
-				idx++
-				for i := range val {
-					binary.BigEndian.PutUint32(p.Options[idx:idx+4], val[i])
-					idx += 4
-				}
-			case [][4]byte: // [1-4][4]byte
-				val := _val.([][4]byte)
-				if len(val) == 0 || len(val) > 4 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val) * 4)
-				idx++
-				for i := range val {
-					copy(p.Options[idx:idx+4], val[i][:4])
-					idx += 4
-				}
-			case []byte: // [(1+n*4)-32]byte
-				val := _val.([]byte)
-				if len(val) == 0 || len(val)%4 != 0 || len(val) > 32 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val))
-				idx++
-				copy(p.Options[idx:idx+len(val)], val)
-				idx += len(val)
-			default:
-				return errors.New("Invalid option type")
-			}
-
-		// Same type as above, except no maximum of 4 addresses
-		// uint32 / [1+]uint32 / [1+n*4]byte / [1+][4]byte
-		case OptionNISServers, OptionNTPServers, OptionNetBIOSNameServers,
-			OptionNetBIOSDistServers, OptionFontServers, OptionXDisplayManager,
-			OptionNISPlusServers, OptionSMTPServers, OptionPOPServers,
-			OptionNNTPServers, OptionWWWServers, OptionFingerServers,
-			OptionIRCServers, OptionStreetTalkServers, OptionSTDAServers:
-			switch _val.(type) {
-			case uint32: // uint32
-				val := _val.(uint32)
-				p.Options[idx] = 4
-				idx++
-				binary.BigEndian.PutUint32(p.Options[idx:idx+4], val)
-				idx += 4
-			case []uint32: // [1+]uint32
-				val := _val.([]uint32)
-				if len(val) == 0 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val) * 4)
-				idx++
-				for i := range val {
-					binary.BigEndian.PutUint32(p.Options[idx:idx+4], val[i])
-					idx += 4
-				}
-			case [][4]byte: // [1+][4]byte
-				val := _val.([][4]byte)
-				if len(val) == 0 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val) * 4)
-				idx++
-				for i := range val {
-					copy(p.Options[idx:idx+4], val[i][:4])
-					idx += 4
-				}
-			case []byte: // [1+n*4]byte
-				val := _val.([]byte)
-				if len(val) == 0 || len(val)%4 != 0 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val))
-				idx++
-				copy(p.Options[idx:idx+len(val)], val)
-				idx += len(val)
-			default:
-				return errors.New("Invalid option type")
-			}
-
-		// Same type as above, except no minimum of 1 address
-		// uint32 / []uint32 / [n*4]byte / [][4]byte
-		case OptionHomeAgentAddrs:
-			switch _val.(type) {
-			case uint32: // uint32
-				val := _val.(uint32)
-				if val == 0 {
-					continue
-				}
-				p.Options[idx] = 4
-				idx++
-				binary.BigEndian.PutUint32(p.Options[idx:idx+4], val)
-				idx += 4
-			case []uint32: // []uint32
-				val := _val.([]uint32)
-				if len(val) == 0 {
-					continue
-				}
-				p.Options[idx] = uint8(len(val) * 4)
-				idx++
-				for i := range val {
-					binary.BigEndian.PutUint32(p.Options[idx:idx+4], val[i])
-					idx += 4
-				}
-			case [][4]byte: // [][4]byte
-				val := _val.([][4]byte)
-				if len(val) == 0 {
-					continue
-				}
-				p.Options[idx] = uint8(len(val) * 4)
-				idx++
-				for i := range val {
-					copy(p.Options[idx:idx+4], val[i][:4])
-					idx += 4
-				}
-			case []byte: // [n*4]byte
-				val := _val.([]byte)
-				if len(val) == 0 {
-					continue
-				}
-				if len(val)%4 != 0 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val))
-				idx++
-				copy(p.Options[idx:idx+len(val)], val)
-				idx += len(val)
-			default:
-				return errors.New("Invalid option type")
-			}
-
-		// [2]uint32 / [1-4][2]uint32 / [(n*8)-64]byte / [1-4][2][4]byte
-		case OptionPolicyFilters, OptionStaticRoutes:
-			switch _val.(type) {
-			case [2]uint32: // [2]uint32
-				val := _val.([2]uint32)
-				p.Options[idx] = 8
-				idx++
-				binary.BigEndian.PutUint32(p.Options[idx:idx+4], val[0])
-				idx += 4
-				binary.BigEndian.PutUint32(p.Options[idx:idx+4], val[1])
-				idx += 4
-			case [][2]uint32: // [1-4][2]uint32
-				val := _val.([][2]uint32)
-				if len(val) == 0 || len(val) > 4 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val) * 8)
-				idx++
-				for i := 0; i < len(val); i++ {
-					binary.BigEndian.PutUint32(p.Options[idx:idx+4], val[i][0])
-					idx += 4
-					binary.BigEndian.PutUint32(p.Options[idx:idx+4], val[i][1])
-					idx += 4
-				}
-			case [][2][4]byte: // [1-4][2][4]byte
-				val := _val.([][2][4]byte)
-				if len(val) == 0 || len(val) > 4 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val) * 2 * 4)
-				idx++
-				for i := 0; i < len(val); i++ {
-					copy(p.Options[idx:idx+4], val[i][0][:4])
-					idx += 4
-					copy(p.Options[idx:idx+4], val[i][1][:4])
-					idx += 4
-				}
-			case []byte: // [(n*8)-64]byte
-				val := _val.([]byte)
-				if len(val) == 0 || len(val)%8 != 0 || len(val) > 64 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val))
-				idx++
-				copy(p.Options[idx:idx+len(val)], val)
-				idx += len(val)
-			default:
-				return errors.New("Invalid option type")
-			}
-
-		// uint32 / [4]byte
-		case OptionSubnetMask, OptionTimeOffset, OptionSwapServer,
-			OptionMTUAgingTimeout, OptionBroadcastAddr, OptionRouterSolicitationAddr,
-			OptionARPCacheTimeout, OptionTCPKeepaliveInterval, OptionRequestedIPAddr,
-			OptionIPAddrLeaseTime, OptionServerID, OptionRenewalTime,
-			OptionRebindingTime:
-			p.Options[idx] = 4
-			idx++
-			switch _val.(type) {
-			case uint32:
-				val := _val.(uint32)
-				binary.BigEndian.PutUint32(p.Options[idx:idx+4], val)
-			case [4]byte:
-				val := _val.([4]byte)
-				copy(p.Options[idx:idx+4], val[:4])
-			default:
-				return errors.New("Invalid option type")
-			}
-			idx += 4
-
-		// uint16 / [1+]uint16 / [2]byte / [1+][2]byte
-		case OptionMTUPlateauTable:
-			switch _val.(type) {
-			case uint16:
-				val := _val.(uint16)
-				if val < 68 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = 2
-				idx++
-				binary.BigEndian.PutUint16(p.Options[idx:idx+2], val)
-				idx += 2
-			case []uint16:
-				val := _val.([]uint16)
-				if len(val) == 0 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val) * 2)
-				idx++
-				for i := range val {
-					if val[i] < 68 {
-						return errors.New("Invalid option value")
-					}
-					binary.BigEndian.PutUint16(p.Options[idx:idx+2], val[i])
-					idx += 2
-				}
-			case [][2]byte:
-				val := _val.([][2]byte)
-				if len(val) == 0 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = uint8(len(val) * 2)
-				idx++
-				for i := range val {
-					if binary.BigEndian.Uint16(val[i][:2]) < 68 {
-						return errors.New("Invalid option value")
-					}
-					copy(p.Options[idx:idx+2], val[i][:2])
-					idx += 2
+	idx := 4 + copy(p.Options[4:], fields[0].data)
+	if overload != 0 {
+		idx += copy(p.Options[idx:], []byte{OptionOverload, 1, overload})
+	}
+	p.Options[idx] = OptionEnd
+
+	// copy options to overloaded fields, terminated with OptionEnd
+	for _, f := range fields[1:] {
+		if overload&f.overload != 0 {
+			n := copy(f.buf, f.data)
+			f.buf[n] = OptionEnd
+		}
+	}
+
+	return nil
+}
+
+// encodedOption is an option code and its encoded value
+type encodedOption struct {
+	code uint8
+	val  []byte
+}
+
+// size returns the number of bytes needed to store the option, split
+// into multiple options of at most 255 bytes each if needed
+func (o encodedOption) size() int {
+	n := 2 + len(o.val)
+	if len(o.val) > 255 {
+		n = len(o.val) + 2*((len(o.val)+254)/255)
+	}
+	return n
+}
+
+// OptionOverload values
+const (
+	overloadFile  uint8 = 1
+	overloadSname uint8 = 2
+)
+
+// optionField is a buffer options are laid out in by SetOptions
+type optionField struct {
+	buf      []byte
+	data     []byte
+	overload uint8
+}
+
+// room returns how many bytes of an option value of length n can be appended to
+// the field as a single option, leaving room for OptionEnd, or -1 if it does not fit.
+// As described in RFC3396, only options longer than 255 bytes are split to fill a field.
+func (f *optionField) room(n int, split bool) int {
+	if n > 255 {
+		n = 255
+	}
+	free := len(f.buf) - 1 - len(f.data) - 2
+	if n <= free {
+		return n
+	}
+	if split && free > 0 {
+		return free
+	}
+	return -1
+}
+
+// fits returns true if all encoded options fit in the field
+func fits(f *optionField, encoded []encodedOption) bool {
+	n := 0
+	for _, opt := range encoded {
+		n += opt.size()
+	}
+	return n <= len(f.buf)-1
+}
+
+// isZero returns true if all bytes in buf are zero
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeOption encodes the value of a single option.
+// Currently supports a wide variety of types for all RFC2132 options.
+func encodeOption(code uint8, _val interface{}) ([]byte, error) {
+	buf := []byte{}
+
+	switch code {
+	// uint32 / [1-4]uint32 / [(1+n*4)-32]byte / [1-4][4]byte
+	case OptionRouters, OptionTimeServers, OptionNameServers,
+		OptionDomainNameServers, OptionLogServers, OptionCookieServers,
+		OptionLPRServers, OptionImpressServers, OptionResourceLocationServers:
+		switch _val.(type) {
+		case uint32: // uint32
+			val := _val.(uint32)
+			buf = binary.BigEndian.AppendUint32(buf, val)
+		case []uint32: // [1-4]uint32
+			val := _val.([]uint32)
+			if len(val) == 0 || len(val) > 4 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := range val {
+				buf = binary.BigEndian.AppendUint32(buf, val[i])
+			}
+		case [][4]byte: // [1-4][4]byte
+			val := _val.([][4]byte)
+			if len(val) == 0 || len(val) > 4 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := range val {
+				buf = append(buf, val[i][:4]...)
+			}
+		case []byte: // [(1+n*4)-32]byte
+			val := _val.([]byte)
+			if len(val) == 0 || len(val)%4 != 0 || len(val) > 32 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// Same type as above, except no maximum of 4 addresses
+	// uint32 / [1+]uint32 / [1+n*4]byte / [1+][4]byte
+	case OptionNISServers, OptionNTPServers, OptionNetBIOSNameServers,
+		OptionNetBIOSDistServers, OptionFontServers, OptionXDisplayManager,
+		OptionNISPlusServers, OptionSMTPServers, OptionPOPServers,
+		OptionNNTPServers, OptionWWWServers, OptionFingerServers,
+		OptionIRCServers, OptionStreetTalkServers, OptionSTDAServers:
+		switch _val.(type) {
+		case uint32: // uint32
+			val := _val.(uint32)
+			buf = binary.BigEndian.AppendUint32(buf, val)
+		case []uint32: // [1+]uint32
+			val := _val.([]uint32)
+			if len(val) == 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := range val {
+				buf = binary.BigEndian.AppendUint32(buf, val[i])
+			}
+		case [][4]byte: // [1+][4]byte
+			val := _val.([][4]byte)
+			if len(val) == 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := range val {
+				buf = append(buf, val[i][:4]...)
+			}
+		case []byte: // [1+n*4]byte
+			val := _val.([]byte)
+			if len(val) == 0 || len(val)%4 != 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// Same type as above, except no minimum of 1 address
+	// uint32 / []uint32 / [n*4]byte / [][4]byte
+	case OptionHomeAgentAddrs:
+		switch _val.(type) {
+		case uint32: // uint32
+			val := _val.(uint32)
+			if val != 0 {
+				buf = binary.BigEndian.AppendUint32(buf, val)
+			}
+		case []uint32: // []uint32
+			val := _val.([]uint32)
+			for i := range val {
+				buf = binary.BigEndian.AppendUint32(buf, val[i])
+			}
+		case [][4]byte: // [][4]byte
+			val := _val.([][4]byte)
+			for i := range val {
+				buf = append(buf, val[i][:4]...)
+			}
+		case []byte: // [n*4]byte
+			val := _val.([]byte)
+			if len(val)%4 != 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// [2]uint32 / [1-4][2]uint32 / [(n*8)-64]byte / [1-4][2][4]byte
+	case OptionPolicyFilters, OptionStaticRoutes:
+		switch _val.(type) {
+		case [2]uint32: // [2]uint32
+			val := _val.([2]uint32)
+			buf = binary.BigEndian.AppendUint32(buf, val[0])
+			buf = binary.BigEndian.AppendUint32(buf, val[1])
+		case [][2]uint32: // [1-4][2]uint32
+			val := _val.([][2]uint32)
+			if len(val) == 0 || len(val) > 4 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := 0; i < len(val); i++ {
+				buf = binary.BigEndian.AppendUint32(buf, val[i][0])
+				buf = binary.BigEndian.AppendUint32(buf, val[i][1])
+			}
+		case [][2][4]byte: // [1-4][2][4]byte
+			val := _val.([][2][4]byte)
+			if len(val) == 0 || len(val) > 4 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := 0; i < len(val); i++ {
+				buf = append(buf, val[i][0][:4]...)
+				buf = append(buf, val[i][1][:4]...)
+			}
+		case []byte: // [(n*8)-64]byte
+			val := _val.([]byte)
+			if len(val) == 0 || len(val)%8 != 0 || len(val) > 64 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// uint32 / [4]byte
+	case OptionSubnetMask, OptionTimeOffset, OptionSwapServer,
+		OptionMTUAgingTimeout, OptionBroadcastAddr, OptionRouterSolicitationAddr,
+		OptionARPCacheTimeout, OptionTCPKeepaliveInterval, OptionRequestedIPAddr,
+		OptionIPAddrLeaseTime, OptionServerID, OptionRenewalTime,
+		OptionRebindingTime:
+		switch _val.(type) {
+		case uint32:
+			val := _val.(uint32)
+			buf = binary.BigEndian.AppendUint32(buf, val)
+		case [4]byte:
+			val := _val.([4]byte)
+			buf = append(buf, val[:4]...)
+		case []byte:
+			val := _val.([]byte)
+			if len(val) != 4 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// uint16 / [1+]uint16 / [2]byte / [1+][2]byte / [2+n*2]byte
+	case OptionMTUPlateauTable:
+		switch _val.(type) {
+		case uint16:
+			val := _val.(uint16)
+			if val < 68 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = binary.BigEndian.AppendUint16(buf, val)
+		case []uint16:
+			val := _val.([]uint16)
+			if len(val) == 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := range val {
+				if val[i] < 68 {
+					return nil, errors.New("Invalid option value")
 				}
-			case [2]byte:
-				val := _val.([2]byte)
-				if binary.BigEndian.Uint16(val[:2]) < 68 {
-					return errors.New("Invalid option value")
+				buf = binary.BigEndian.AppendUint16(buf, val[i])
+			}
+		case [][2]byte:
+			val := _val.([][2]byte)
+			if len(val) == 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := range val {
+				if binary.BigEndian.Uint16(val[i][:2]) < 68 {
+					return nil, errors.New("Invalid option value")
 				}
-				p.Options[idx] = 2
-				idx++
-				copy(p.Options[idx:idx+2], val[:2])
-				idx += 2
-			default:
-				return errors.New("Invalid option type")
-			}
-
-		// uint16 / [2]byte
-		case OptionBootFileSize, OptionMaxDatagramAssembly, OptionInterfaceMTU,
-			OptionMaxMessageSize:
-			p.Options[idx] = 2
-			idx++
-			switch _val.(type) {
-			case uint16:
-				val := _val.(uint16)
-				binary.BigEndian.PutUint16(p.Options[idx:idx+2], val)
-			case [2]byte:
-				val := _val.([2]byte)
-				copy(p.Options[idx:idx+2], val[:2])
-			default:
-				return errors.New("Invalid option type")
-			}
-			idx += 2
-
-		// uint8 / byte
-		case OptionMessageType, OptionOverload, OptionDefaultIPTTL,
-			OptionTCPDefaultTTL, OptionNetBIOSNodeType:
-			p.Options[idx] = 1
-			idx++
-			switch _val.(type) {
-			case uint8:
-				val := _val.(uint8)
-				if code == OptionOverload && (val == 0 || val > 3) {
-					return errors.New("Invalid option value")
+				buf = append(buf, val[i][:2]...)
+			}
+		case [2]byte:
+			val := _val.([2]byte)
+			if binary.BigEndian.Uint16(val[:2]) < 68 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val[:2]...)
+		case []byte:
+			val := _val.([]byte)
+			if len(val) == 0 || len(val)%2 != 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			for i := 0; i < len(val); i += 2 {
+				if binary.BigEndian.Uint16(val[i:i+2]) < 68 {
+					return nil, errors.New("Invalid option value")
 				}
-				p.Options[idx] = val
-			default:
-				return errors.New("Invalid option type")
 			}
-			idx++
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
 
-		// (uint8 / byte - 0/1) / bool
-		case OptionIPForwardingEnable, OptionSourceRoutingEnable, OptionAllSubnetsAreLocal,
-			OptionMaskDiscoveryEnable, OptionMaskSupplier, OptionRouterDiscoveryEnable,
-			OptionTrailerEncapsulation, OptionEthernetEncapsulation, OptionTCPKeepaliveGarbage:
-			p.Options[idx] = 1
-			idx++
-			switch _val.(type) {
-			case uint8:
-				val := _val.(uint8)
-				if val > 1 {
-					return errors.New("Invalid option value")
-				}
-				p.Options[idx] = val
-			case bool:
-				val := _val.(bool)
-				if val {
-					p.Options[idx] = 1
-				} else {
-					p.Options[idx] = 0
-				}
-			default:
-				return errors.New("Invalid option type")
+	// uint16 / [2]byte
+	case OptionBootFileSize, OptionMaxDatagramAssembly, OptionInterfaceMTU,
+		OptionMaxMessageSize:
+		switch _val.(type) {
+		case uint16:
+			val := _val.(uint16)
+			buf = binary.BigEndian.AppendUint16(buf, val)
+		case [2]byte:
+			val := _val.([2]byte)
+			buf = append(buf, val[:2]...)
+		case []byte:
+			val := _val.([]byte)
+			if len(val) != 2 {
+				return nil, errors.New("Invalid option value")
 			}
-			idx++
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
 
-		// []uint8 / []byte
-		case OptionParameterList:
-			switch _val.(type) {
-			case []byte:
-				val := _val.([]byte)
-				p.Options[idx] = uint8(len(val))
-				idx++
-				if code == OptionClientID && len(val) < 2 {
-					return errors.New("Invalid option value")
-				}
-				copy(p.Options[idx:idx+len(val)], val)
-				idx += len(val)
-			default:
-				return errors.New("Invalid option type")
-			}
-
-		// string / []byte
-		case OptionMeritDumpFile, OptionDomainName, OptionRootPath,
-			OptionExtensionsPath, OptionMessage, OptionNISDomain,
-			OptionNetBIOSScope, OptionNISPlusDomainName, OptionTFTPServerName,
-			OptionBootFileName, OptionHostname:
-			switch _val.(type) {
-			case string:
-				val := _val.(string)
-				p.Options[idx] = uint8(len(val))
-				idx++
-				copy(p.Options[idx:idx+len(val)], []byte(val))
-				idx += len(val)
-			case []byte:
-				val := _val.([]byte)
-				p.Options[idx] = uint8(len(val))
-				idx++
-				copy(p.Options[idx:idx+len(val)], val)
-				idx += len(val)
-			default:
-				return errors.New("Invalid option type")
-			}
-
-		// []byte
-		case OptionClassID, OptionClientID, OptionVendorSpecificOptions:
-			fallthrough
+	// uint8 / byte / [1]byte
+	case OptionMessageType, OptionOverload, OptionDefaultIPTTL,
+		OptionTCPDefaultTTL, OptionNetBIOSNodeType:
+		switch _val.(type) {
+		case uint8:
+			val := _val.(uint8)
+			if code == OptionOverload && (val == 0 || val > 3) {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val)
+		case []byte:
+			val := _val.([]byte)
+			if len(val) != 1 {
+				return nil, errors.New("Invalid option value")
+			}
+			if code == OptionOverload && (val[0] == 0 || val[0] > 3) {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
 		default:
-			switch _val.(type) {
-			case []byte:
-				val := _val.([]byte)
-				if len(val) == 0 {
-					return errors.New("Invalid option value")
-				}
-				if code == OptionClientID && len(val) < 2 {
-					return errors.New("Invalid option value")
-				}
+			return nil, errors.New("Invalid option type")
+		}
 
-				p.Options[idx] = uint8(len(val))
-				idx++
-				copy(p.Options[idx:idx+len(val)], val)
-				idx += len(val)
-			default:
-				return errors.New("Invalid option type")
+	// (uint8 / byte - 0/1) / bool / [1]byte
+	case OptionIPForwardingEnable, OptionSourceRoutingEnable, OptionAllSubnetsAreLocal,
+		OptionMaskDiscoveryEnable, OptionMaskSupplier, OptionRouterDiscoveryEnable,
+		OptionTrailerEncapsulation, OptionEthernetEncapsulation, OptionTCPKeepaliveGarbage:
+		switch _val.(type) {
+		case uint8:
+			val := _val.(uint8)
+			if val > 1 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val)
+		case bool:
+			val := _val.(bool)
+			if val {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case []byte:
+			val := _val.([]byte)
+			if len(val) != 1 || val[0] > 1 {
+				return nil, errors.New("Invalid option value")
 			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
 		}
-	}
 
-	p.Options[idx] = OptionEnd
+	// []uint8 / []byte
+	case OptionParameterList:
+		switch _val.(type) {
+		case []byte:
+			val := _val.([]byte)
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
 
-	return nil
+	// string / []byte
+	case OptionMeritDumpFile, OptionDomainName, OptionRootPath,
+		OptionExtensionsPath, OptionMessage, OptionNISDomain,
+		OptionNetBIOSScope, OptionNISPlusDomainName, OptionTFTPServerName,
+		OptionBootFileName, OptionHostname:
+		switch _val.(type) {
+		case string:
+			val := _val.(string)
+			buf = append(buf, val...)
+		case []byte:
+			val := _val.([]byte)
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// []ClasslessRoute
+	case OptionClasslessRoutes:
+		switch _val.(type) {
+		case []ClasslessRoute:
+			val, err := encodeClasslessRoutes(_val.([]ClasslessRoute))
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, val...)
+		case []byte:
+			val := _val.([]byte)
+			if _, err := decodeClasslessRoutes(val); err != nil {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// []string / []byte
+	case OptionDomainSearch:
+		switch _val.(type) {
+		case []string:
+			val, err := encodeDomainSearch(_val.([]string))
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, val...)
+		case []byte:
+			val := _val.([]byte)
+			if _, err := decodeDomainSearch(val); err != nil {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// FQDN / *FQDN / [3+]byte
+	case OptionFQDN:
+		switch _val.(type) {
+		case FQDN:
+			val := _val.(FQDN)
+			enc, err := val.encode()
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, enc...)
+		case *FQDN:
+			enc, err := _val.(*FQDN).encode()
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, enc...)
+		case []byte:
+			val := _val.([]byte)
+			if _, err := decodeFQDN(val); err != nil {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// *Authentication / [11+]byte
+	case OptionAuthentication:
+		switch _val.(type) {
+		case *Authentication:
+			val, err := _val.(*Authentication).encode()
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, val...)
+		case []byte:
+			val := _val.([]byte)
+			if _, err := decodeAuthentication(val); err != nil {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// RelayAgentInfo / *RelayAgentInfo / []byte
+	case OptionRelayAgentOptions:
+		switch _val.(type) {
+		case RelayAgentInfo:
+			val := _val.(RelayAgentInfo)
+			enc, err := val.encode()
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, enc...)
+		case *RelayAgentInfo:
+			enc, err := _val.(*RelayAgentInfo).encode()
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, enc...)
+		case []byte:
+			val := _val.([]byte)
+			if _, err := decodeRelayAgentInfo(val); err != nil {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// VendorOptions / []byte
+	case OptionVendorSpecificOptions:
+		switch _val.(type) {
+		case VendorOptions:
+			val, err := _val.(VendorOptions).encode()
+			if err != nil {
+				return nil, err
+			}
+			if len(val) == 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		case []byte:
+			// not necessarily encapsulated, so any value is accepted
+			val := _val.([]byte)
+			if len(val) == 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// VendorClasses / []byte
+	case OptionVendorClass:
+		switch _val.(type) {
+		case VendorClasses:
+			val, err := _val.(VendorClasses).encode()
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, val...)
+		case []byte:
+			val := _val.([]byte)
+			if _, err := decodeVendorClasses(val); err != nil {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// VendorInfo / []byte
+	case OptionVendorInfo:
+		switch _val.(type) {
+		case VendorInfo:
+			val, err := _val.(VendorInfo).encode()
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, val...)
+		case []byte:
+			val := _val.([]byte)
+			if _, err := decodeVendorInfo(val); err != nil {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+
+	// []byte
+	case OptionClassID, OptionClientID:
+		fallthrough
+	default:
+		switch _val.(type) {
+		case []byte:
+			val := _val.([]byte)
+			if len(val) == 0 {
+				return nil, errors.New("Invalid option value")
+			}
+			if code == OptionClientID && len(val) < 2 {
+				return nil, errors.New("Invalid option value")
+			}
+			buf = append(buf, val...)
+		default:
+			return nil, errors.New("Invalid option type")
+		}
+	}
+
+	return buf, nil
 }
 
 func (p *Packet) toBytes() ([]byte, error) {
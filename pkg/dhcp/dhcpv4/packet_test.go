@@ -0,0 +1,246 @@
+package dhcpv4
+
+import (
+	"bytes"
+	"testing"
+)
+
+// rawOptions returns the options in buf as code and value pairs, stopping at OptionEnd
+func rawOptions(t *testing.T, buf []byte) [][2][]byte {
+	t.Helper()
+
+	opts := [][2][]byte{}
+	for idx := 0; idx < len(buf) && buf[idx] != OptionEnd; {
+		if buf[idx] == OptionPad {
+			idx++
+			continue
+		}
+		if idx+1 >= len(buf) || idx+2+int(buf[idx+1]) > len(buf) {
+			t.Fatalf("truncated option at offset %d", idx)
+		}
+		opts = append(opts, [2][]byte{{buf[idx]}, buf[idx+2 : idx+2+int(buf[idx+1])]})
+		idx += 2 + int(buf[idx+1])
+	}
+	return opts
+}
+
+// minMessageSize is the smallest maximum message size, leaving 308 bytes for options
+const minMessageSize = int(dhcpOptionsLenMin + dhcpFixedLen)
+
+func TestSetOptionsOverloadsFileAndSname(t *testing.T) {
+	opts := Options{
+		OptionMessageType: MessageTypeAck,
+		OptionHostname:    string(bytes.Repeat([]byte{'h'}, 200)),
+		OptionDomainName:  string(bytes.Repeat([]byte{'d'}, 100)),
+		OptionNISDomain:   string(bytes.Repeat([]byte{'n'}, 60)),
+	}
+
+	p := &Packet{}
+	if err := p.SetOptionsMaxSize(opts, minMessageSize); err != nil {
+		t.Fatalf("SetOptionsMaxSize: %v", err)
+	}
+	if n := p.optionsLen(); n > int(dhcpOptionsLenMin) {
+		t.Errorf("options field has length %d, want at most %d", n, dhcpOptionsLenMin)
+	}
+
+	// options which do not fit are laid out in the file field, then the sname field
+	got := p.GetOptions()
+	if overload, err := got.Overload(); err != nil || overload != overloadFile|overloadSname {
+		t.Errorf("OptionOverload = %d, %v, want %d", overload, err, overloadFile|overloadSname)
+	}
+	if opt := rawOptions(t, p.BootFilename[:]); len(opt) != 1 || opt[0][0][0] != OptionDomainName {
+		t.Errorf("file field does not hold OptionDomainName")
+	}
+	if opt := rawOptions(t, p.ServerHostname[:]); len(opt) != 1 || opt[0][0][0] != OptionNISDomain {
+		t.Errorf("sname field does not hold OptionNISDomain")
+	}
+	for _, code := range []uint8{OptionHostname, OptionDomainName, OptionNISDomain} {
+		if v, _ := got.Bytes(code); string(v) != opts[code].(string) {
+			t.Errorf("option %d = %q, want %q", code, v, opts[code])
+		}
+	}
+}
+
+func TestSetOptionsKeepsUsedFileField(t *testing.T) {
+	p := &Packet{}
+	copy(p.BootFilename[:], "pxelinux.0")
+
+	if err := p.SetOptionsMaxSize(Options{
+		OptionMessageType: MessageTypeAck,
+		OptionHostname:    string(bytes.Repeat([]byte{'h'}, 250)),
+		OptionDomainName:  string(bytes.Repeat([]byte{'d'}, 60)),
+	}, minMessageSize); err != nil {
+		t.Fatalf("SetOptionsMaxSize: %v", err)
+	}
+
+	if overload, err := p.GetOptions().Overload(); err != nil || overload != overloadSname {
+		t.Errorf("OptionOverload = %d, %v, want %d", overload, err, overloadSname)
+	}
+	if !bytes.HasPrefix(p.BootFilename[:], []byte("pxelinux.0\x00")) {
+		t.Errorf("file field was overwritten: %q", p.BootFilename[:16])
+	}
+	if v, _ := p.GetOptions().Bytes(OptionDomainName); len(v) != 60 {
+		t.Errorf("OptionDomainName has length %d, want 60", len(v))
+	}
+}
+
+func TestSetOptionsClearsOverloadedFields(t *testing.T) {
+	p := &Packet{}
+	if err := p.SetOptionsMaxSize(Options{
+		OptionMessageType: MessageTypeAck,
+		OptionHostname:    string(bytes.Repeat([]byte{'h'}, 250)),
+		OptionDomainName:  string(bytes.Repeat([]byte{'d'}, 100)),
+	}, minMessageSize); err != nil {
+		t.Fatalf("SetOptionsMaxSize: %v", err)
+	}
+	if isZero(p.BootFilename[:]) {
+		t.Fatalf("file field is not overloaded")
+	}
+
+	if err := p.SetOptions(Options{OptionMessageType: MessageTypeAck}); err != nil {
+		t.Fatalf("SetOptions: %v", err)
+	}
+	if !isZero(p.BootFilename[:]) || !isZero(p.ServerHostname[:]) {
+		t.Errorf("overloaded fields were not cleared")
+	}
+	if _, err := p.GetOptions().Overload(); err == nil {
+		t.Errorf("OptionOverload is still set")
+	}
+}
+
+func TestSetOptionsFillsOptionsFieldWithFieldsInUse(t *testing.T) {
+	// options and OptionEnd exactly fill the options field
+	room := minMessageSize - int(dhcpFixedLen) - len(dhcpCookie) - 1
+	opts := Options{
+		OptionHostname:   string(bytes.Repeat([]byte{'h'}, 200)),
+		OptionDomainName: string(bytes.Repeat([]byte{'d'}, room-202-2)),
+	}
+
+	p := &Packet{}
+	copy(p.ServerHostname[:], "server")
+	copy(p.BootFilename[:], "pxelinux.0")
+	if err := p.SetOptionsMaxSize(opts, minMessageSize); err != nil {
+		t.Fatalf("SetOptionsMaxSize: %v", err)
+	}
+	if _, err := p.GetOptions().Overload(); err == nil {
+		t.Errorf("OptionOverload is set")
+	}
+	if v, _ := p.GetOptions().Bytes(OptionDomainName); len(v) != room-204 {
+		t.Errorf("OptionDomainName has length %d, want %d", len(v), room-204)
+	}
+
+	// one more byte does not fit, and the used fields can not be overloaded
+	opts[OptionDomainName] = string(bytes.Repeat([]byte{'d'}, room-202-1))
+	if err := p.SetOptionsMaxSize(opts, minMessageSize); err == nil {
+		t.Errorf("SetOptionsMaxSize accepted options larger than the options field")
+	}
+	if !bytes.HasPrefix(p.ServerHostname[:], []byte("server\x00")) || !bytes.HasPrefix(p.BootFilename[:], []byte("pxelinux.0\x00")) {
+		t.Errorf("sname or file field was overwritten")
+	}
+}
+
+func TestSetOptionsTooLarge(t *testing.T) {
+	p := &Packet{}
+	err := p.SetOptionsMaxSize(Options{
+		OptionMessageType: MessageTypeAck,
+		OptionHostname:    string(bytes.Repeat([]byte{'h'}, 250)),
+		OptionDomainName:  string(bytes.Repeat([]byte{'d'}, 250)),
+		OptionNISDomain:   string(bytes.Repeat([]byte{'n'}, 250)),
+	}, minMessageSize)
+	if err == nil {
+		t.Errorf("SetOptionsMaxSize accepted options larger than the packet")
+	}
+}
+
+func sequence(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestSetOptionsSplitsLongOptions(t *testing.T) {
+	val := sequence(600)
+
+	p := &Packet{}
+	if err := p.SetOptions(Options{
+		OptionMessageType:           MessageTypeAck,
+		OptionVendorSpecificOptions: val,
+	}); err != nil {
+		t.Fatalf("SetOptions: %v", err)
+	}
+
+	// RFC3396: the value is split into consecutive options of at most 255 bytes
+	opts := rawOptions(t, p.Options[len(dhcpCookie):])
+	if len(opts) != 4 {
+		t.Fatalf("got %d options, want 4", len(opts))
+	}
+	if opts[0][0][0] != OptionMessageType {
+		t.Errorf("first option is %d, want OptionMessageType", opts[0][0][0])
+	}
+	for i, want := range []int{255, 255, 90} {
+		opt := opts[i+1]
+		if opt[0][0] != OptionVendorSpecificOptions {
+			t.Errorf("option %d has code %d, want %d", i+1, opt[0][0], OptionVendorSpecificOptions)
+		}
+		if len(opt[1]) != want {
+			t.Errorf("option %d has length %d, want %d", i+1, len(opt[1]), want)
+		}
+	}
+
+	got, err := p.GetOptions().Bytes(OptionVendorSpecificOptions)
+	if err != nil {
+		t.Fatalf("Options.Bytes: %v", err)
+	}
+	if !bytes.Equal(got, val) {
+		t.Errorf("concatenated value does not match the original value")
+	}
+}
+
+func TestSetOptionsKeepsShortOptionsWhole(t *testing.T) {
+	p := &Packet{}
+	if err := p.SetOptions(Options{
+		OptionMessageType:           MessageTypeAck,
+		OptionVendorSpecificOptions: sequence(255),
+	}); err != nil {
+		t.Fatalf("SetOptions: %v", err)
+	}
+
+	opts := rawOptions(t, p.Options[len(dhcpCookie):])
+	if len(opts) != 2 || len(opts[1][1]) != 255 {
+		t.Errorf("255 byte option was split: %d options", len(opts))
+	}
+}
+
+func TestParseOptionsConcatenates(t *testing.T) {
+	buf := []byte{
+		OptionHostname, 2, 'a', 'b',
+		OptionPad,
+		OptionDomainName, 3, 'l', 'a', 'n',
+		OptionHostname, 3, 'c', 'd', 'e',
+		OptionEnd,
+		OptionHostname, 1, 'x',
+	}
+
+	opts := Options{}
+	parseOptions(buf, opts)
+	if got, _ := opts.Bytes(OptionHostname); string(got) != "abcde" {
+		t.Errorf("OptionHostname = %q, want %q", got, "abcde")
+	}
+	if got, _ := opts.Bytes(OptionDomainName); string(got) != "lan" {
+		t.Errorf("OptionDomainName = %q, want %q", got, "lan")
+	}
+}
+
+func TestParseOptionsStopsAtTruncatedOption(t *testing.T) {
+	opts := Options{}
+	parseOptions([]byte{OptionHostname, 2, 'a', 'b', OptionDomainName, 5, 'l', 'a'}, opts)
+
+	if got, _ := opts.Bytes(OptionHostname); string(got) != "ab" {
+		t.Errorf("OptionHostname = %q, want %q", got, "ab")
+	}
+	if _, ok := opts[OptionDomainName]; ok {
+		t.Errorf("truncated OptionDomainName was parsed")
+	}
+}
@@ -0,0 +1,145 @@
+package dhcpv4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Relay Agent Information sub-options (RFC3046)
+const (
+	RelayAgentCircuitID        uint8 = 1  // RFC3046
+	RelayAgentRemoteID         uint8 = 2  // RFC3046
+	RelayAgentLinkSelection    uint8 = 5  // RFC3527
+	RelayAgentSubscriberID     uint8 = 6  // RFC3993
+	RelayAgentServerIDOverride uint8 = 11 // RFC5107
+)
+
+// RelayAgentInfo is the value of OptionRelayAgentOptions (RFC3046), which relay agents
+// add to requests they forward and servers must echo unmodified in their replies
+type RelayAgentInfo struct {
+	CircuitID        []byte
+	RemoteID         []byte
+	LinkSelection    net.IP
+	SubscriberID     string
+	ServerIDOverride net.IP
+
+	// Other contains sub-options without a dedicated field, keyed by sub-option code
+	Other map[uint8][]byte
+}
+
+// encode encodes the sub-options of r in ascending order of their code
+func (r *RelayAgentInfo) encode() ([]byte, error) {
+	subopts := map[uint8][]byte{}
+	for code, val := range r.Other {
+		subopts[code] = val
+	}
+	if r.CircuitID != nil {
+		subopts[RelayAgentCircuitID] = r.CircuitID
+	}
+	if r.RemoteID != nil {
+		subopts[RelayAgentRemoteID] = r.RemoteID
+	}
+	if r.LinkSelection != nil {
+		if r.LinkSelection.To4() == nil {
+			return nil, errors.New("Invalid link selection address")
+		}
+		subopts[RelayAgentLinkSelection] = r.LinkSelection.To4()
+	}
+	if r.SubscriberID != "" {
+		subopts[RelayAgentSubscriberID] = []byte(r.SubscriberID)
+	}
+	if r.ServerIDOverride != nil {
+		if r.ServerIDOverride.To4() == nil {
+			return nil, errors.New("Invalid server identifier override address")
+		}
+		subopts[RelayAgentServerIDOverride] = r.ServerIDOverride.To4()
+	}
+	if len(subopts) == 0 {
+		return nil, errors.New("Invalid option value")
+	}
+
+	codes := make([]int, 0, len(subopts))
+	for code := range subopts {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+
+	buf := []byte{}
+	for _, code := range codes {
+		val := subopts[uint8(code)]
+		if len(val) > 255 {
+			return nil, fmt.Errorf("Sub-option %d too long", code)
+		}
+		buf = append(buf, uint8(code), uint8(len(val)))
+		buf = append(buf, val...)
+	}
+	return buf, nil
+}
+
+// decodeRelayAgentInfo decodes the sub-options of OptionRelayAgentOptions
+func decodeRelayAgentInfo(v []byte) (*RelayAgentInfo, error) {
+	if len(v) < 2 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), OptionRelayAgentOptions)
+	}
+
+	r := &RelayAgentInfo{}
+	for idx := 0; idx < len(v); {
+		if idx+2 > len(v) || idx+2+int(v[idx+1]) > len(v) {
+			return nil, fmt.Errorf("Truncated sub-option in option %d", OptionRelayAgentOptions)
+		}
+		code, val := v[idx], append([]byte{}, v[idx+2:idx+2+int(v[idx+1])]...)
+		idx += 2 + len(val)
+
+		switch code {
+		case RelayAgentCircuitID:
+			r.CircuitID = val
+		case RelayAgentRemoteID:
+			r.RemoteID = val
+		case RelayAgentLinkSelection, RelayAgentServerIDOverride:
+			if len(val) != 4 {
+				return nil, fmt.Errorf("Invalid length %d for sub-option %d of option %d", len(val), code, OptionRelayAgentOptions)
+			}
+			if code == RelayAgentLinkSelection {
+				r.LinkSelection = net.IPv4(val[0], val[1], val[2], val[3])
+			} else {
+				r.ServerIDOverride = net.IPv4(val[0], val[1], val[2], val[3])
+			}
+		case RelayAgentSubscriberID:
+			r.SubscriberID = string(val)
+		default:
+			if r.Other == nil {
+				r.Other = map[uint8][]byte{}
+			}
+			r.Other[code] = val
+		}
+	}
+	return r, nil
+}
+
+// RelayAgentInfo decodes OptionRelayAgentOptions
+func (o Options) RelayAgentInfo() (*RelayAgentInfo, error) {
+	v, err := o.Bytes(OptionRelayAgentOptions)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRelayAgentInfo(v)
+}
+
+// RelayAgentInfo decodes OptionRelayAgentOptions of the packet
+func (p *Packet) RelayAgentInfo() (*RelayAgentInfo, error) {
+	return p.GetOptions().RelayAgentInfo()
+}
+
+// SetRelayAgentInfo adds OptionRelayAgentOptions to the options of the packet,
+// replacing any relay agent information already present. As required by RFC3046,
+// the option is placed after all other options.
+func (p *Packet) SetRelayAgentInfo(r *RelayAgentInfo) error {
+	opts := p.GetOptions()
+	opts[OptionRelayAgentOptions] = r
+	if err := p.SetOptions(opts); err != nil {
+		return fmt.Errorf("Packet.SetOptions: %v", err)
+	}
+	return nil
+}
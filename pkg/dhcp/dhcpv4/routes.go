@@ -0,0 +1,145 @@
+package dhcpv4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ClasslessRoute is a route as described by the Classless Static Route Option (RFC3442).
+// A Router of 0.0.0.0 means the destination is directly reachable on the interface.
+type ClasslessRoute struct {
+	Dest   *net.IPNet
+	Router net.IP
+}
+
+// encodeClasslessRoutes encodes routes in the RFC3442 destination descriptor format
+func encodeClasslessRoutes(routes []ClasslessRoute) ([]byte, error) {
+	if len(routes) == 0 {
+		return nil, errors.New("Invalid option value")
+	}
+
+	buf := []byte{}
+	for _, r := range routes {
+		if r.Dest == nil || r.Dest.IP.To4() == nil || r.Router.To4() == nil {
+			return nil, errors.New("Invalid option value")
+		}
+		ones, bits := r.Dest.Mask.Size()
+		if bits != 32 {
+			return nil, errors.New("Invalid option value")
+		}
+		dest := r.Dest.IP.To4().Mask(r.Dest.Mask)
+
+		buf = append(buf, uint8(ones))
+		buf = append(buf, dest[:(ones+7)/8]...)
+		buf = append(buf, r.Router.To4()...)
+	}
+	return buf, nil
+}
+
+// decodeClasslessRoutes decodes routes in the RFC3442 destination descriptor format
+func decodeClasslessRoutes(v []byte) ([]ClasslessRoute, error) {
+	if len(v) < 5 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), OptionClasslessRoutes)
+	}
+
+	routes := []ClasslessRoute{}
+	for idx := 0; idx < len(v); {
+		ones := int(v[idx])
+		if ones > 32 {
+			return nil, fmt.Errorf("Invalid prefix length %d in option %d", ones, OptionClasslessRoutes)
+		}
+		idx++
+
+		octets := (ones + 7) / 8
+		if idx+octets+4 > len(v) {
+			return nil, fmt.Errorf("Truncated route in option %d", OptionClasslessRoutes)
+		}
+		dest := make(net.IP, 4)
+		copy(dest, v[idx:idx+octets])
+		idx += octets
+		router := net.IPv4(v[idx], v[idx+1], v[idx+2], v[idx+3])
+		idx += 4
+
+		mask := net.CIDRMask(ones, 32)
+		routes = append(routes, ClasslessRoute{
+			Dest:   &net.IPNet{IP: dest.Mask(mask), Mask: mask},
+			Router: router,
+		})
+	}
+	return routes, nil
+}
+
+// ClasslessRoutes decodes OptionClasslessRoutes
+func (o Options) ClasslessRoutes() ([]ClasslessRoute, error) {
+	v, err := o.Bytes(OptionClasslessRoutes)
+	if err != nil {
+		return nil, err
+	}
+	return decodeClasslessRoutes(v)
+}
+
+// StaticRoutes decodes OptionStaticRoutes. The destinations of these routes are
+// classful, so their netmask is derived from the class of the destination address.
+func (o Options) StaticRoutes() ([]ClasslessRoute, error) {
+	v, err := o.Bytes(OptionStaticRoutes)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 || len(v)%8 != 0 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(v), OptionStaticRoutes)
+	}
+
+	routes := []ClasslessRoute{}
+	for i := 0; i < len(v); i += 8 {
+		dest := net.IPv4(v[i], v[i+1], v[i+2], v[i+3]).To4()
+		if dest.Equal(net.IPv4zero) {
+			// the default route is not allowed in this option
+			continue
+		}
+		mask := dest.DefaultMask()
+		routes = append(routes, ClasslessRoute{
+			Dest:   &net.IPNet{IP: dest.Mask(mask), Mask: mask},
+			Router: net.IPv4(v[i+4], v[i+5], v[i+6], v[i+7]),
+		})
+	}
+	return routes, nil
+}
+
+// Routes returns the routes a client should install. As required by RFC3442,
+// OptionRouters and OptionStaticRoutes are ignored if OptionClasslessRoutes is present.
+// Otherwise, the first router in OptionRouters is used as the default route.
+func (o Options) Routes() ([]ClasslessRoute, error) {
+	if _, ok := o[OptionClasslessRoutes]; ok {
+		return o.ClasslessRoutes()
+	}
+
+	routes := []ClasslessRoute{}
+	static, err := o.StaticRoutes()
+	if err != nil && err != ErrNoOption {
+		return nil, err
+	}
+	routes = append(routes, static...)
+
+	routers, err := o.Routers()
+	if err != nil && err != ErrNoOption {
+		return nil, err
+	}
+	if len(routers) > 0 {
+		routes = append(routes, ClasslessRoute{
+			Dest:   &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			Router: routers[0],
+		})
+	}
+	return routes, nil
+}
+
+// ClasslessRoutes decodes OptionClasslessRoutes of the packet
+func (p *Packet) ClasslessRoutes() ([]ClasslessRoute, error) {
+	return p.GetOptions().ClasslessRoutes()
+}
+
+// Routes returns the routes a client should install, see Options.Routes
+func (p *Packet) Routes() ([]ClasslessRoute, error) {
+	return p.GetOptions().Routes()
+}
@@ -0,0 +1,75 @@
+package dhcpv4
+
+import (
+	"bytes"
+	"testing"
+)
+
+// the destination descriptor examples of RFC3442 section 3, each via 10.0.0.1
+var classlessRouteTests = []struct {
+	dest    string
+	encoded []byte
+}{
+	{"0.0.0.0/0", []byte{0, 10, 0, 0, 1}},
+	{"10.0.0.0/8", []byte{8, 10, 10, 0, 0, 1}},
+	{"10.17.0.0/16", []byte{16, 10, 17, 10, 0, 0, 1}},
+	{"10.27.129.0/24", []byte{24, 10, 27, 129, 10, 0, 0, 1}},
+	{"10.229.0.128/25", []byte{25, 10, 229, 0, 128, 10, 0, 0, 1}},
+	{"10.198.122.47/32", []byte{32, 10, 198, 122, 47, 10, 0, 0, 1}},
+}
+
+func TestDecodeClasslessRoutes(t *testing.T) {
+	buf := []byte{}
+	for _, tt := range classlessRouteTests {
+		buf = append(buf, tt.encoded...)
+	}
+
+	routes, err := decodeClasslessRoutes(buf)
+	if err != nil {
+		t.Fatalf("decodeClasslessRoutes: %v", err)
+	}
+	if len(routes) != len(classlessRouteTests) {
+		t.Fatalf("got %d routes, want %d", len(routes), len(classlessRouteTests))
+	}
+	for i, tt := range classlessRouteTests {
+		if got := routes[i].Dest.String(); got != tt.dest {
+			t.Errorf("route %d has destination %s, want %s", i, got, tt.dest)
+		}
+		if got := routes[i].Router.String(); got != "10.0.0.1" {
+			t.Errorf("route %d has router %s, want 10.0.0.1", i, got)
+		}
+	}
+
+	enc, err := encodeClasslessRoutes(routes)
+	if err != nil {
+		t.Fatalf("encodeClasslessRoutes: %v", err)
+	}
+	if !bytes.Equal(enc, buf) {
+		t.Errorf("encodeClasslessRoutes = %v, want %v", enc, buf)
+	}
+}
+
+func TestDecodeClasslessRoutesMasksDestination(t *testing.T) {
+	// bits beyond the prefix length are not part of the destination
+	routes, err := decodeClasslessRoutes([]byte{4, 0xff, 10, 0, 0, 1})
+	if err != nil {
+		t.Fatalf("decodeClasslessRoutes: %v", err)
+	}
+	if got := routes[0].Dest.String(); got != "240.0.0.0/4" {
+		t.Errorf("destination is %s, want 240.0.0.0/4", got)
+	}
+}
+
+func TestDecodeClasslessRoutesInvalid(t *testing.T) {
+	for name, buf := range map[string][]byte{
+		"empty":            {},
+		"short":            {0, 10, 0, 0},
+		"prefix too long":  {33, 10, 0, 0, 1, 10, 0, 0, 1},
+		"truncated router": {0, 10, 0, 0, 1, 8, 10, 10, 0, 0},
+		"truncated dest":   {0, 10, 0, 0, 1, 32, 10, 0},
+	} {
+		if routes, err := decodeClasslessRoutes(buf); err == nil {
+			t.Errorf("%s: decodeClasslessRoutes = %v, want error", name, routes)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package dhcpv4
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Reasons passed to Client.Script in the reason environment variable, as used by ISC dhclient-script
+const (
+	ScriptReasonBound   = "BOUND"   // a new lease was obtained
+	ScriptReasonRenew   = "RENEW"   // the lease was extended by the leasing server
+	ScriptReasonRebind  = "REBIND"  // the lease was extended by another server
+	ScriptReasonReboot  = "REBOOT"  // the previous lease was confirmed after a restart
+	ScriptReasonExpire  = "EXPIRE"  // the lease expired or was rejected by the server
+	ScriptReasonFail    = "FAIL"    // no lease could be obtained
+	ScriptReasonRelease = "RELEASE" // the lease was released
+)
+
+// scriptReason returns the reason for binding a lease in the given state
+func scriptReason(state dhcpState) string {
+	switch state {
+	case stateRenewing:
+		return ScriptReasonRenew
+	case stateRebinding:
+		return ScriptReasonRebind
+	case stateRebooting:
+		return ScriptReasonReboot
+	}
+	return ScriptReasonBound
+}
+
+// joinIPs formats addresses as a space separated list
+func joinIPs(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// leaseEnv returns the dhclient-script environment variables describing a lease,
+// with their names prefixed by prefix
+func leaseEnv(prefix string, l *Lease) []string {
+	if l == nil {
+		return nil
+	}
+
+	env := []string{}
+	set := func(name, val string) {
+		if val != "" {
+			env = append(env, prefix+name+"="+val)
+		}
+	}
+
+	set("ip_address", l.IP.String())
+	if l.SubnetMask != nil {
+		set("subnet_mask", net.IP(l.SubnetMask).String())
+		set("network_number", l.IP.Mask(l.SubnetMask).String())
+		brd := make(net.IP, 4)
+		for i, b := range l.IP.Mask(l.SubnetMask).To4() {
+			brd[i] = b | ^l.SubnetMask[i]
+		}
+		if ip, err := l.Options.BroadcastAddr(); err == nil {
+			brd = ip
+		}
+		set("broadcast_address", brd.String())
+	}
+	set("routers", joinIPs(l.Routers))
+	if routes, err := l.Options.StaticRoutes(); err == nil {
+		s := []string{}
+		for _, r := range routes {
+			s = append(s, r.Dest.IP.String(), r.Router.String())
+		}
+		set("static_routes", strings.Join(s, " "))
+	}
+	if v, err := l.Options.Bytes(OptionClasslessRoutes); err == nil {
+		s := make([]string, len(v))
+		for i, b := range v {
+			s[i] = strconv.Itoa(int(b))
+		}
+		set("rfc3442_classless_static_routes", strings.Join(s, " "))
+	}
+	set("domain_name_servers", joinIPs(l.DNSServers))
+	set("domain_name", l.DomainName)
+	set("domain_search", strings.Join(l.DomainSearch, " "))
+	if name, err := l.Options.Hostname(); err == nil {
+		set("host_name", name)
+	}
+	if ips, err := l.Options.NTPServers(); err == nil {
+		set("ntp_servers", joinIPs(ips))
+	}
+	if mtu, err := l.Options.InterfaceMTU(); err == nil {
+		set("interface_mtu", strconv.Itoa(int(mtu)))
+	}
+	if l.ServerID != nil {
+		set("dhcp_server_identifier", l.ServerID.String())
+	}
+	set("dhcp_lease_time", strconv.FormatInt(int64(l.LeaseTime.Seconds()), 10))
+	set("dhcp_renewal_time", strconv.FormatInt(int64(l.RenewalTime.Seconds()), 10))
+	set("dhcp_rebinding_time", strconv.FormatInt(int64(l.RebindingTime.Seconds()), 10))
+	set("expiry", strconv.FormatInt(l.Expiry().Unix(), 10))
+	return env
+}
+
+// runScript runs c.Script, if set, with the reason and the previous and current
+// lease in its environment, in the manner of ISC dhclient-script
+func (c *Client) runScript(reason string, prev, cur *Lease) {
+	if c.Script == "" {
+		return
+	}
+
+	cmd := exec.Command(c.Script)
+	cmd.Env = append(os.Environ(), "reason="+reason, "interface="+c.Interface.Name)
+	cmd.Env = append(cmd.Env, leaseEnv("old_", prev)...)
+	cmd.Env = append(cmd.Env, leaseEnv("new_", cur)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		c.logger().Warn("Script failed", "script", c.Script, "reason", reason, "error", err, "output", string(out))
+		return
+	}
+	c.logger().Debug("Script succeeded", "script", c.Script, "reason", reason, "output", string(out))
+}
@@ -1 +1,664 @@
 package dhcpv4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"../internal/ifnet"
+)
+
+// defaultLeaseTime is the lease time used when Server.LeaseTime is not set
+const defaultLeaseTime = 12 * time.Hour
+
+// offerHoldTime is how long an offered address is reserved for a client
+const offerHoldTime = time.Minute
+
+// BindingState is the state of an address binding on the server
+type BindingState uint8
+
+// Binding States
+const (
+	BindingOffered BindingState = iota + 1
+	BindingBound
+	BindingDeclined
+	BindingReleased
+	BindingReserved
+)
+
+// Binding is the association between a client and an address leased by the server
+type Binding struct {
+	ClientID     []byte
+	HardwareAddr net.HardwareAddr
+	IP           net.IP
+	State        BindingState
+	Expiry       time.Time
+	Hostname     string
+}
+
+// Active returns true if the binding holds its address at the given time
+func (b *Binding) Active(now time.Time) bool {
+	return b.State == BindingReserved || (b.State != BindingReleased && now.Before(b.Expiry))
+}
+
+// Server is a DHCPv4 server. Requests are passed to Handler if set,
+// otherwise the server hands out addresses from a single address pool.
+type Server struct {
+	Interface  *net.Interface
+	Handler    Handler
+	ServerID   net.IP
+	PoolStart  net.IP
+	PoolEnd    net.IP
+	SubnetMask net.IPMask
+	LeaseTime  time.Duration
+	Options    map[uint8]interface{}
+	Leases     LeaseStore
+
+	// VendorInfo is the vendor-specific information sent in OptionVendorInfo to clients
+	// which identify themselves as a client of the enterprise using OptionVendorClass
+	VendorInfo VendorInfo
+
+	// AuthKeys enables delayed authentication (RFC3118) for clients which request it.
+	// Replies to these clients are signed using the secret identified by AuthKeyID,
+	// and their requests are dropped unless authenticated using one of AuthKeys.
+	AuthKeys  KeyStore
+	AuthKeyID uint32
+
+	// DNSUpdate is called to update the A record of a client which asks the server to
+	// do so using OptionFQDN (RFC4702). Partial names are passed as sent by the client.
+	// If nil, clients are told that the server does not perform DNS updates.
+	DNSUpdate func(name string, ip net.IP) error
+
+	// Logger receives the log records of the server. If nil, the server is silent.
+	Logger *slog.Logger
+
+	mu     sync.Mutex
+	conn   *ifnet.UDPConn
+	mux    *ServeMux
+	replay map[string]uint64
+	pool   sync.Mutex // serialises the built-in handlers, which check and update bindings
+}
+
+func (s *Server) init() error {
+	if s.Interface == nil {
+		return errors.New("Interface not set")
+	}
+
+	if s.ServerID == nil {
+		ip, err := findSourceIPv4(s.Interface)
+		if err != nil {
+			return fmt.Errorf("findSourceIPv4: %v", err)
+		}
+		s.ServerID = ip
+	}
+
+	if s.Handler != nil {
+		return nil
+	}
+
+	if s.PoolStart.To4() == nil || s.PoolEnd.To4() == nil {
+		return errors.New("Address pool not set")
+	}
+	if ipToUint32(s.PoolStart) > ipToUint32(s.PoolEnd) {
+		return errors.New("Invalid address pool")
+	}
+
+	if s.SubnetMask == nil {
+		s.SubnetMask = s.PoolStart.DefaultMask()
+	}
+
+	if s.LeaseTime == 0 {
+		s.LeaseTime = defaultLeaseTime
+	}
+
+	if s.Options == nil {
+		s.Options = map[uint8]interface{}{}
+	}
+
+	if s.Leases == nil {
+		s.Leases = NewMemoryLeaseStore()
+	}
+
+	// make sure the server address is never handed out
+	if s.inPool(s.ServerID) {
+		key := serverKey(keyReserved, s.ServerID)
+		if b, err := s.Leases.Get(key); err != nil {
+			return fmt.Errorf("LeaseStore.Get: %v", err)
+		} else if b == nil {
+			if err := s.Leases.Update(&Binding{
+				ClientID: key,
+				IP:       s.ServerID.To4(),
+				State:    BindingReserved,
+			}); err != nil {
+				return fmt.Errorf("LeaseStore.Update: %v", err)
+			}
+		}
+	}
+
+	if s.mux == nil {
+		s.mux = NewServeMux()
+		s.mux.Handle(MessageTypeDiscover, s.poolHandler(s.handleDiscover))
+		s.mux.Handle(MessageTypeRequest, s.poolHandler(s.handleRequest))
+		s.mux.Handle(MessageTypeDecline, s.poolHandler(s.handleDecline))
+		s.mux.Handle(MessageTypeRelease, s.poolHandler(s.handleRelease))
+		s.mux.Handle(MessageTypeInform, s.poolHandler(s.handleInform))
+	}
+
+	return nil
+}
+
+// logger returns the logger of the server
+func (s *Server) logger() *slog.Logger {
+	return orDiscard(s.Logger)
+}
+
+// handler returns the handler serving requests
+func (s *Server) handler() Handler {
+	if s.Handler != nil {
+		return s.Handler
+	}
+	return s.mux
+}
+
+// poolHandler adapts a built-in address pool handler to the Handler interface.
+// Handlers run one at a time, so an address can not be bound to two clients
+// between checking whether it is held and updating its binding.
+func (s *Server) poolHandler(handle func(req *Packet, opts Options) (*Packet, error)) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Packet) {
+		s.pool.Lock()
+		reply, err := handle(req, req.GetOptions())
+		s.pool.Unlock()
+		if err != nil {
+			s.logger().Warn("Failed to serve packet", append(packetAttrs(req), "src", w.RemoteAddr(), "error", err)...)
+			return
+		}
+		if reply == nil {
+			return
+		}
+		if err := w.Write(reply); err != nil {
+			s.logger().Warn("Failed to reply", append(packetAttrs(req), "src", w.RemoteAddr(), "error", err)...)
+		}
+	})
+}
+
+// ListenAndServe listens on the DHCP server port of the interface and answers
+// client requests until Close is called
+func (s *Server) ListenAndServe() error {
+	if err := s.init(); err != nil {
+		return fmt.Errorf("Server.init: %v", err)
+	}
+
+	ln, err := ifnet.ListenUDP("udp4", &net.UDPAddr{
+		IP:   net.IPv4zero,
+		Port: portServer,
+	}, s.Interface)
+	if err != nil {
+		return fmt.Errorf("ifnet.ListenUDP: %v", err)
+	}
+
+	s.mu.Lock()
+	s.conn = ln
+	s.mu.Unlock()
+
+	s.logger().Info("Starting DHCP server", "interface", s.Interface.Name, "server_id", s.ServerID)
+
+	data := make([]byte, dhcpMaxPacketSize)
+	for {
+		// clear buffer
+		for i := range data {
+			data[i] = 0
+		}
+
+		// read packet
+		n, src, err := ln.ReadFromUDP(data)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.conn == nil
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return fmt.Errorf("ifnet.UDPConn.ReadFromUDP: %v", err)
+		}
+		if n < int(dhcpFixedNonUDP)+len(dhcpCookie) {
+			s.logger().Debug("Received short packet", "src", src, "len", n)
+			continue
+		}
+
+		// parse packet
+		req, err := parsePacket(data)
+		if err != nil {
+			s.logger().Debug("Failed to parse packet", "src", src, "error", err)
+			continue
+		}
+		s.logger().Debug("Received packet", append(packetAttrs(req), "src", src, "len", n, "data", hexData(data[:n]))...)
+		if req.Operation != OpRequest {
+			continue
+		}
+
+		w := &responseWriter{
+			conn:   ln,
+			lif:    s.Interface,
+			req:    req,
+			raddr:  src,
+			logger: s.logger(),
+		}
+		if s.AuthKeys != nil {
+			if _, err := req.Authentication(); err == nil {
+				if err := s.verify(req, data[:n]); err != nil {
+					s.logger().Warn("Dropping unauthenticated packet", append(packetAttrs(req), "src", src, "error", err)...)
+					continue
+				}
+				w.sign = func(reply *Packet) error {
+					return s.sign(req, reply)
+				}
+			}
+		}
+
+		go s.handler().ServeDHCP(w, req)
+	}
+}
+
+// verify checks the authentication of a raw request which carries OptionAuthentication.
+// Requests only asking for authentication, as sent by clients in the INIT state, are accepted.
+func (s *Server) verify(req *Packet, data []byte) error {
+	auth, err := req.Authentication()
+	if err != nil {
+		return err
+	}
+	if len(auth.Info) == 0 && auth.Protocol == AuthProtocolDelayed {
+		if t, _ := req.MessageType(); t == MessageTypeDiscover || t == MessageTypeInform {
+			return nil
+		}
+	}
+
+	key := clientKey(req, req.GetOptions())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	auth, err = VerifyAuthentication(data, s.AuthKeys, s.replay[key])
+	if err != nil {
+		return err
+	}
+	if s.replay == nil {
+		s.replay = map[string]uint64{}
+	}
+	s.replay[key] = auth.ReplayDetection
+	return nil
+}
+
+// sign authenticates a reply to a request using the secret identified by s.AuthKeyID
+func (s *Server) sign(req, reply *Packet) error {
+	key, err := s.AuthKeys.Key(s.AuthKeyID)
+	if err != nil {
+		return fmt.Errorf("KeyStore.Key: %v", err)
+	}
+	if key == nil {
+		return fmt.Errorf("Unknown secret ID %d", s.AuthKeyID)
+	}
+	if err := reply.sign(s.AuthKeyID, key, maxMessageSize(req)); err != nil {
+		return fmt.Errorf("Packet.sign: %v", err)
+	}
+	return nil
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	s.mu.Lock()
+	ln := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+
+	if ln == nil {
+		return nil
+	}
+	return ln.Close()
+}
+
+// replyDestination returns where a reply should be sent according to RFC2131 section 4.1
+func replyDestination(req, reply *Packet) *net.UDPAddr {
+	if giaddr := net.IP(req.GatewayIP[:]); !giaddr.Equal(net.IPv4zero) {
+		return &net.UDPAddr{IP: giaddr, Port: portServer}
+	}
+	if t, _ := reply.MessageType(); t != MessageTypeNak {
+		if ciaddr := net.IP(req.ClientIP[:]); !ciaddr.Equal(net.IPv4zero) {
+			return &net.UDPAddr{IP: ciaddr, Port: portClient}
+		}
+	}
+	// without the ability to add an ARP entry for yiaddr,
+	// replies to clients without an address are always broadcast
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: portClient}
+}
+
+// Binding key kinds. Every binding key starts with its kind, so a client can not
+// present the key of a binding the server keeps for itself.
+const (
+	keyClient   byte = 'c' // followed by the client identifier, or the hardware type and address
+	keyReserved byte = 'r' // followed by the reserved address
+	keyDeclined byte = 'd' // followed by the declined address
+)
+
+// clientKey returns a key identifying the client that sent a request,
+// which is the client identifier option if present or otherwise the hardware address
+func clientKey(req *Packet, opts Options) string {
+	if id, err := opts.ClientID(); err == nil {
+		return string(append([]byte{keyClient}, id...))
+	}
+	return string(append([]byte{keyClient, req.HardwareType}, hardwareAddr(req)...))
+}
+
+// serverKey returns the key of a binding the server keeps for itself
+func serverKey(kind byte, ip net.IP) []byte {
+	return append([]byte{kind}, ip.To4().String()...)
+}
+
+// inPool returns true if ip is in the server address pool
+func (s *Server) inPool(ip net.IP) bool {
+	v := ipToUint32(ip)
+	return ip.To4() != nil && v >= ipToUint32(s.PoolStart) && v <= ipToUint32(s.PoolEnd)
+}
+
+// inSubnet returns true if ip is on the same subnet as the address pool
+func (s *Server) inSubnet(ip net.IP) bool {
+	return ip.To4() != nil && ip.Mask(s.SubnetMask).Equal(s.PoolStart.Mask(s.SubnetMask))
+}
+
+// newReply creates a reply packet of the given message type for a request,
+// with extra options added to those of the server
+func (s *Server) newReply(req *Packet, msgType uint8, yiaddr net.IP, leaseTime time.Duration, extra Options) (*Packet, error) {
+	reply, err := NewReply(req, msgType)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := Options{
+		OptionMessageType: msgType,
+		OptionServerID:    toArray4(s.serverID(req)),
+	}
+
+	if msgType != MessageTypeNak {
+		copy(reply.ServerIP[:], s.ServerID.To4())
+		if yiaddr != nil {
+			copy(reply.YourIP[:], yiaddr.To4())
+		}
+		for code, val := range s.Options {
+			opts[code] = val
+		}
+		if _, ok := opts[OptionSubnetMask]; !ok {
+			opts[OptionSubnetMask] = toArray4(net.IP(s.SubnetMask))
+		}
+		if leaseTime > 0 {
+			opts[OptionIPAddrLeaseTime] = uint32(leaseTime / time.Second)
+		}
+		if info := s.vendorInfo(req); info != nil {
+			opts[OptionVendorInfo] = info
+		}
+		for code, val := range extra {
+			opts[code] = val
+		}
+	}
+
+	// relay agent information must be echoed unmodified (RFC3046 section 2.2)
+	if info, err := req.GetOptions().Bytes(OptionRelayAgentOptions); err == nil {
+		if _, err := decodeRelayAgentInfo(info); err == nil {
+			opts[OptionRelayAgentOptions] = info
+		}
+	}
+
+	if err := reply.SetOptionsMaxSize(opts, maxMessageSize(req)); err != nil {
+		return nil, fmt.Errorf("Packet.SetOptionsMaxSize: %v", err)
+	}
+	return reply, nil
+}
+
+// vendorInfo returns the vendor-specific information for the enterprises
+// of the vendor classes of a request (RFC3925 section 4), or nil
+func (s *Server) vendorInfo(req *Packet) VendorInfo {
+	classes, err := req.VendorClasses()
+	if err != nil || len(s.VendorInfo) == 0 {
+		return nil
+	}
+
+	info := VendorInfo{}
+	for e := range classes {
+		if subopts, ok := s.VendorInfo[e]; ok {
+			info[e] = subopts
+		}
+	}
+	if len(info) == 0 {
+		return nil
+	}
+	return info
+}
+
+// serverID returns the server identifier for replies to a request. A relay agent may
+// override it to receive renewals in place of the server (RFC5107).
+func (s *Server) serverID(req *Packet) net.IP {
+	if info, err := req.RelayAgentInfo(); err == nil && info.ServerIDOverride != nil {
+		return info.ServerIDOverride
+	}
+	return s.ServerID
+}
+
+// maxMessageSize returns the maximum size of a DHCP message the client accepts
+func maxMessageSize(req *Packet) int {
+	if v, err := req.GetOptions().MaxMessageSize(); err == nil {
+		return int(v)
+	}
+	return int(dhcpOptionsLenMin + dhcpFixedLen)
+}
+
+// held returns true if ip is held by an active binding of a client other than key
+func (s *Server) held(ip net.IP, key string, now time.Time) (bool, error) {
+	b, err := s.Leases.GetByIP(ip)
+	if err != nil {
+		return false, fmt.Errorf("LeaseStore.GetByIP: %v", err)
+	}
+	return b != nil && b.Active(now) && string(b.ClientID) != key, nil
+}
+
+// handleDiscover answers DHCPDISCOVER with DHCPOFFER (RFC2131 section 4.3.1)
+func (s *Server) handleDiscover(req *Packet, opts Options) (*Packet, error) {
+	key := clientKey(req, opts)
+	now := time.Now()
+
+	b, err := s.Leases.Get([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("LeaseStore.Get: %v", err)
+	}
+
+	// offer the current address to clients which are still bound,
+	// otherwise reserve an address for the client until it sends DHCPREQUEST
+	if b == nil || b.State != BindingBound || !b.Active(now) {
+		b = &Binding{
+			ClientID:     []byte(key),
+			HardwareAddr: hardwareAddr(req),
+			State:        BindingOffered,
+			Expiry:       now.Add(offerHoldTime),
+		}
+		requested, _ := opts.RequestedIP()
+		if err := s.Leases.Allocate(b, requested, s.PoolStart, s.PoolEnd, now); err != nil {
+			return nil, fmt.Errorf("LeaseStore.Allocate: %v", err)
+		}
+	}
+
+	return s.newReply(req, MessageTypeOffer, b.IP, s.LeaseTime, nil)
+}
+
+// handleRequest answers DHCPREQUEST with DHCPACK or DHCPNAK (RFC2131 section 4.3.2)
+func (s *Server) handleRequest(req *Packet, opts Options) (*Packet, error) {
+	key := clientKey(req, opts)
+	now := time.Now()
+	serverID, _ := opts.ServerID()
+	requested, _ := opts.RequestedIP()
+	ciaddr := net.IP(req.ClientIP[:])
+
+	prev, err := s.Leases.Get([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("LeaseStore.Get: %v", err)
+	}
+
+	var ip net.IP
+	switch {
+	case serverID != nil: // SELECTING
+		if !serverID.Equal(s.serverID(req)) {
+			// client selected another server, drop our offer
+			if prev != nil && prev.State == BindingOffered {
+				if err := s.Leases.Release([]byte(key)); err != nil {
+					return nil, fmt.Errorf("LeaseStore.Release: %v", err)
+				}
+			}
+			return nil, nil
+		}
+		ip = requested
+		if ip == nil {
+			ip = ciaddr
+		}
+
+	case requested != nil: // INIT-REBOOT
+		if !s.inSubnet(requested) {
+			return s.newReply(req, MessageTypeNak, nil, 0, nil)
+		}
+		held, err := s.held(requested, key, now)
+		if err != nil {
+			return nil, err
+		}
+		if prev == nil && !held {
+			// no record of this client, remain silent
+			return nil, nil
+		}
+		ip = requested
+
+	case !ciaddr.Equal(net.IPv4zero): // RENEWING or REBINDING
+		ip = ciaddr
+
+	default:
+		return nil, errors.New("Invalid DHCPREQUEST")
+	}
+
+	held, err := s.held(ip, key, now)
+	if err != nil {
+		return nil, err
+	}
+	if !s.inPool(ip) || held {
+		return s.newReply(req, MessageTypeNak, nil, 0, nil)
+	}
+
+	b := &Binding{
+		ClientID:     []byte(key),
+		HardwareAddr: hardwareAddr(req),
+		IP:           ip.To4(),
+		State:        BindingBound,
+		Expiry:       now.Add(s.LeaseTime),
+	}
+	if hostname, err := opts.Hostname(); err == nil {
+		b.Hostname = hostname
+	} else if prev != nil {
+		b.Hostname = prev.Hostname
+	}
+	if err := s.Leases.Update(b); err != nil {
+		return nil, fmt.Errorf("LeaseStore.Update: %v", err)
+	}
+
+	extra := Options{}
+	if fqdn, err := opts.FQDN(); err == nil {
+		extra[OptionFQDN] = s.updateDNS(fqdn, ip)
+	}
+
+	return s.newReply(req, MessageTypeAck, ip, s.LeaseTime, extra)
+}
+
+// updateDNS performs the DNS update requested by a client using OptionFQDN
+// and returns the OptionFQDN value for the reply (RFC4702 section 4)
+func (s *Server) updateDNS(fqdn *FQDN, ip net.IP) *FQDN {
+	reply := &FQDN{
+		Flags:  fqdn.Flags & FQDNFlagEncoding,
+		RCode1: fqdnRCodeServer,
+		RCode2: fqdnRCodeServer,
+		Name:   fqdn.Name,
+	}
+
+	if fqdn.Flags&FQDNFlagNoUpdate != 0 || fqdn.Flags&FQDNFlagServerUpdate == 0 {
+		reply.Flags |= FQDNFlagNoUpdate
+		return reply
+	}
+
+	// the client asked the server to update, so not doing it overrides the S flag (RFC4702 section 4)
+	if s.DNSUpdate == nil || fqdn.Name == "" {
+		reply.Flags |= FQDNFlagNoUpdate | FQDNFlagOverride
+		return reply
+	}
+	if err := s.DNSUpdate(fqdn.Name, ip); err != nil {
+		s.logger().Warn("Failed to update DNS", "name", fqdn.Name, "ip", ip, "error", err)
+		reply.Flags |= FQDNFlagNoUpdate | FQDNFlagOverride
+		return reply
+	}
+	reply.Flags |= FQDNFlagServerUpdate
+	return reply
+}
+
+// handleDecline marks an address reported as in use by a client (RFC2131 section 4.3.3)
+func (s *Server) handleDecline(req *Packet, opts Options) (*Packet, error) {
+	if serverID, err := opts.ServerID(); err == nil && !serverID.Equal(s.serverID(req)) {
+		return nil, nil
+	}
+	ip, err := opts.RequestedIP()
+	if err != nil || !s.inPool(ip) {
+		return nil, errors.New("Invalid DHCPDECLINE")
+	}
+
+	key := clientKey(req, opts)
+	b, err := s.Leases.Get([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("LeaseStore.Get: %v", err)
+	}
+	if b != nil && b.IP.Equal(ip) {
+		if err := s.Leases.Release([]byte(key)); err != nil {
+			return nil, fmt.Errorf("LeaseStore.Release: %v", err)
+		}
+	}
+
+	// keep the address out of the pool under a key no client can present
+	if err := s.Leases.Update(&Binding{
+		ClientID: serverKey(keyDeclined, ip),
+		IP:       ip.To4(),
+		State:    BindingDeclined,
+		Expiry:   time.Now().Add(s.LeaseTime),
+	}); err != nil {
+		return nil, fmt.Errorf("LeaseStore.Update: %v", err)
+	}
+
+	return nil, nil
+}
+
+// handleRelease frees the address of a client (RFC2131 section 4.3.4)
+func (s *Server) handleRelease(req *Packet, opts Options) (*Packet, error) {
+	if serverID, err := opts.ServerID(); err == nil && !serverID.Equal(s.serverID(req)) {
+		return nil, nil
+	}
+
+	key := clientKey(req, opts)
+	b, err := s.Leases.Get([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("LeaseStore.Get: %v", err)
+	}
+	if b != nil && bytes.Equal(b.IP.To4(), req.ClientIP[:]) {
+		if err := s.Leases.Release([]byte(key)); err != nil {
+			return nil, fmt.Errorf("LeaseStore.Release: %v", err)
+		}
+	}
+
+	return nil, nil
+}
+
+// handleInform answers DHCPINFORM with configuration parameters only (RFC2131 section 4.3.5)
+func (s *Server) handleInform(req *Packet, opts Options) (*Packet, error) {
+	if net.IP(req.ClientIP[:]).Equal(net.IPv4zero) {
+		return nil, errors.New("Invalid DHCPINFORM")
+	}
+	return s.newReply(req, MessageTypeAck, nil, 0, nil)
+}
@@ -0,0 +1,95 @@
+package dhcpv4
+
+import (
+	"net"
+	"testing"
+)
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+
+	s := &Server{
+		Interface: &net.Interface{Index: 1, Name: "test0", HardwareAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}},
+		ServerID:  net.IPv4(10, 99, 0, 1).To4(),
+		PoolStart: net.IPv4(10, 99, 0, 1).To4(),
+		PoolEnd:   net.IPv4(10, 99, 0, 20).To4(),
+	}
+	if err := s.init(); err != nil {
+		t.Fatalf("Server.init: %v", err)
+	}
+	return s
+}
+
+// testRequest creates a request from the client with the given client identifier
+func testRequest(t *testing.T, msgType uint8, clientID []byte, ciaddr net.IP, opts Options) *Packet {
+	t.Helper()
+
+	p := &Packet{Operation: OpRequest, HardwareType: HardwareTypeEthernet, HardwareLength: 6, TransactionID: 1}
+	copy(p.ClientHardwareAddress[:], []byte{0x02, 0, 0, 0, 0, 1})
+	copy(p.ClientIP[:], ciaddr.To4())
+	opts[OptionMessageType] = msgType
+	opts[OptionClientID] = clientID
+	if err := p.SetOptions(opts); err != nil {
+		t.Fatalf("SetOptions: %v", err)
+	}
+	return p
+}
+
+func TestServerOwnedBindingsCanNotBeSpoofed(t *testing.T) {
+	s := testServer(t)
+
+	// a client declines an address, which the server then keeps out of the pool
+	declined := net.IPv4(10, 99, 0, 5).To4()
+	decline := testRequest(t, MessageTypeDecline, []byte{0, 'a'}, nil, Options{OptionRequestedIPAddr: toArray4(declined)})
+	if _, err := s.handleDecline(decline, decline.GetOptions()); err != nil {
+		t.Fatalf("handleDecline: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		ip    net.IP
+		state BindingState
+	}{
+		{"reserved", s.ServerID, BindingReserved},
+		{"declined", declined, BindingDeclined},
+	} {
+		b, err := s.Leases.GetByIP(tt.ip)
+		if err != nil || b == nil || b.State != tt.state {
+			t.Fatalf("%s: binding of %s is %+v, %v", tt.name, tt.ip, b, err)
+		}
+
+		for _, id := range [][]byte{b.ClientID, []byte(tt.name + " " + tt.ip.String())} {
+			// INIT-REBOOT for the address
+			req := testRequest(t, MessageTypeRequest, id, nil, Options{OptionRequestedIPAddr: toArray4(tt.ip)})
+			reply, err := s.handleRequest(req, req.GetOptions())
+			if err != nil {
+				t.Fatalf("%s: handleRequest: %v", tt.name, err)
+			}
+			if reply != nil {
+				if msgType, _ := reply.MessageType(); msgType == MessageTypeAck {
+					t.Errorf("%s: DHCPREQUEST with client identifier %q was acknowledged", tt.name, id)
+				}
+			}
+
+			// release of the address
+			req = testRequest(t, MessageTypeRelease, id, tt.ip, Options{OptionServerID: toArray4(s.ServerID)})
+			if _, err := s.handleRelease(req, req.GetOptions()); err != nil {
+				t.Fatalf("%s: handleRelease: %v", tt.name, err)
+			}
+
+			// discovery preferring the previous address of the client
+			req = testRequest(t, MessageTypeDiscover, id, nil, Options{})
+			offer, err := s.handleDiscover(req, req.GetOptions())
+			if err != nil {
+				t.Fatalf("%s: handleDiscover: %v", tt.name, err)
+			}
+			if net.IP(offer.YourIP[:]).Equal(tt.ip) {
+				t.Errorf("%s: client identifier %q was offered %s", tt.name, id, tt.ip)
+			}
+
+			if b, _ := s.Leases.GetByIP(tt.ip); b == nil || b.State != tt.state {
+				t.Errorf("%s: client identifier %q changed the binding of %s to %+v", tt.name, id, tt.ip, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package dhcpv4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// VendorOptions are encapsulated vendor-specific sub-options keyed by sub-option code,
+// as carried by OptionVendorSpecificOptions (RFC2132 section 8.4) and OptionVendorInfo
+type VendorOptions map[uint8][]byte
+
+// VendorClasses are the vendor class data items of OptionVendorClass (RFC3925)
+// keyed by IANA enterprise number
+type VendorClasses map[uint32][][]byte
+
+// VendorInfo are the vendor-specific sub-options of OptionVendorInfo (RFC3925)
+// keyed by IANA enterprise number
+type VendorInfo map[uint32]VendorOptions
+
+// encode encodes the sub-options in ascending order of their code
+func (v VendorOptions) encode() ([]byte, error) {
+	codes := make([]int, 0, len(v))
+	for code := range v {
+		if code == OptionPad || code == OptionEnd {
+			return nil, fmt.Errorf("Invalid vendor sub-option code %d", code)
+		}
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+
+	buf := []byte{}
+	for _, code := range codes {
+		val := v[uint8(code)]
+		if len(val) > 255 {
+			return nil, fmt.Errorf("Vendor sub-option %d too long", code)
+		}
+		buf = append(buf, uint8(code), uint8(len(val)))
+		buf = append(buf, val...)
+	}
+	return buf, nil
+}
+
+// decodeVendorOptions decodes encapsulated vendor-specific sub-options
+func decodeVendorOptions(data []byte) (VendorOptions, error) {
+	v := VendorOptions{}
+	for idx := 0; idx < len(data); {
+		switch data[idx] {
+		case OptionPad:
+			idx++
+			continue
+		case OptionEnd:
+			return v, nil
+		}
+		if idx+2 > len(data) || idx+2+int(data[idx+1]) > len(data) {
+			return nil, errors.New("Truncated vendor sub-option")
+		}
+		code, n := data[idx], int(data[idx+1])
+		v[code] = append(v[code], data[idx+2:idx+2+n]...)
+		idx += 2 + n
+	}
+	return v, nil
+}
+
+// sortEnterprises returns enterprise numbers in ascending order
+func sortEnterprises(enterprises []uint32) []uint32 {
+	sort.Slice(enterprises, func(i, j int) bool { return enterprises[i] < enterprises[j] })
+	return enterprises
+}
+
+// encode encodes the vendor classes in ascending order of their enterprise number
+func (v VendorClasses) encode() ([]byte, error) {
+	if len(v) == 0 {
+		return nil, errors.New("Invalid option value")
+	}
+
+	enterprises := make([]uint32, 0, len(v))
+	for e := range v {
+		enterprises = append(enterprises, e)
+	}
+	buf := []byte{}
+	for _, e := range sortEnterprises(enterprises) {
+		data := []byte{}
+		for _, item := range v[e] {
+			if len(item) == 0 || len(item) > 255 {
+				return nil, fmt.Errorf("Invalid vendor class data length %d for enterprise %d", len(item), e)
+			}
+			data = append(data, uint8(len(item)))
+			data = append(data, item...)
+		}
+		if len(data) > 255 {
+			return nil, fmt.Errorf("Vendor class data for enterprise %d too long", e)
+		}
+		buf = binary.BigEndian.AppendUint32(buf, e)
+		buf = append(buf, uint8(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+// decodeVendorClasses decodes the value of OptionVendorClass
+func decodeVendorClasses(data []byte) (VendorClasses, error) {
+	if len(data) < 5 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(data), OptionVendorClass)
+	}
+
+	v := VendorClasses{}
+	for idx := 0; idx < len(data); {
+		if idx+5 > len(data) || idx+5+int(data[idx+4]) > len(data) {
+			return nil, fmt.Errorf("Truncated vendor class in option %d", OptionVendorClass)
+		}
+		e := binary.BigEndian.Uint32(data[idx : idx+4])
+		end := idx + 5 + int(data[idx+4])
+		idx += 5
+
+		items := v[e]
+		for idx < end {
+			n := int(data[idx])
+			if n == 0 || idx+1+n > end {
+				return nil, fmt.Errorf("Invalid vendor class data for enterprise %d in option %d", e, OptionVendorClass)
+			}
+			items = append(items, append([]byte{}, data[idx+1:idx+1+n]...))
+			idx += 1 + n
+		}
+		v[e] = items
+	}
+	return v, nil
+}
+
+// encode encodes the vendor-specific information in ascending order of enterprise number
+func (v VendorInfo) encode() ([]byte, error) {
+	if len(v) == 0 {
+		return nil, errors.New("Invalid option value")
+	}
+
+	enterprises := make([]uint32, 0, len(v))
+	for e := range v {
+		enterprises = append(enterprises, e)
+	}
+	buf := []byte{}
+	for _, e := range sortEnterprises(enterprises) {
+		data, err := v[e].encode()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 255 {
+			return nil, fmt.Errorf("Vendor-specific information for enterprise %d too long", e)
+		}
+		buf = binary.BigEndian.AppendUint32(buf, e)
+		buf = append(buf, uint8(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+// decodeVendorInfo decodes the value of OptionVendorInfo
+func decodeVendorInfo(data []byte) (VendorInfo, error) {
+	if len(data) < 5 {
+		return nil, fmt.Errorf("Invalid length %d for option %d", len(data), OptionVendorInfo)
+	}
+
+	v := VendorInfo{}
+	for idx := 0; idx < len(data); {
+		if idx+5 > len(data) || idx+5+int(data[idx+4]) > len(data) {
+			return nil, fmt.Errorf("Truncated vendor-specific information in option %d", OptionVendorInfo)
+		}
+		e := binary.BigEndian.Uint32(data[idx : idx+4])
+		end := idx + 5 + int(data[idx+4])
+
+		subopts, err := decodeVendorOptions(data[idx+5 : end])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid vendor-specific information for enterprise %d: %v", e, err)
+		}
+		if v[e] == nil {
+			v[e] = VendorOptions{}
+		}
+		for code, val := range subopts {
+			v[e][code] = append(v[e][code], val...)
+		}
+		idx = end
+	}
+	return v, nil
+}
+
+// VendorOptions decodes OptionVendorSpecificOptions as encapsulated vendor-specific sub-options
+func (o Options) VendorOptions() (VendorOptions, error) {
+	v, err := o.Bytes(OptionVendorSpecificOptions)
+	if err != nil {
+		return nil, err
+	}
+	return decodeVendorOptions(v)
+}
+
+// VendorClasses decodes OptionVendorClass
+func (o Options) VendorClasses() (VendorClasses, error) {
+	v, err := o.Bytes(OptionVendorClass)
+	if err != nil {
+		return nil, err
+	}
+	return decodeVendorClasses(v)
+}
+
+// VendorInfo decodes OptionVendorInfo
+func (o Options) VendorInfo() (VendorInfo, error) {
+	v, err := o.Bytes(OptionVendorInfo)
+	if err != nil {
+		return nil, err
+	}
+	return decodeVendorInfo(v)
+}
+
+// VendorOptions decodes OptionVendorSpecificOptions of the packet
+func (p *Packet) VendorOptions() (VendorOptions, error) {
+	return p.GetOptions().VendorOptions()
+}
+
+// VendorClasses decodes OptionVendorClass of the packet
+func (p *Packet) VendorClasses() (VendorClasses, error) {
+	return p.GetOptions().VendorClasses()
+}
+
+// VendorInfo decodes OptionVendorInfo of the packet
+func (p *Packet) VendorInfo() (VendorInfo, error) {
+	return p.GetOptions().VendorInfo()
+}
@@ -0,0 +1,503 @@
+package dbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultSystemBusAddress is the address of the system bus when DBUS_SYSTEM_BUS_ADDRESS is not set
+const defaultSystemBusAddress = "unix:path=/var/run/dbus/system_bus_socket"
+
+// maxMessageLen is the maximum length of a message (D-Bus specification, "Message Format")
+const maxMessageLen = 1 << 27
+
+// message types
+const (
+	typeMethodCall   uint8 = 1
+	typeMethodReturn uint8 = 2
+	typeError        uint8 = 3
+)
+
+// header fields
+const (
+	fieldPath        uint8 = 1
+	fieldInterface   uint8 = 2
+	fieldMember      uint8 = 3
+	fieldErrorName   uint8 = 4
+	fieldReplySerial uint8 = 5
+	fieldDestination uint8 = 6
+	fieldSignature   uint8 = 8
+)
+
+// Error is an error reply to a method call
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// Conn is a minimal D-Bus connection which supports calling methods
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+}
+
+// SystemBus connects to the system message bus
+func SystemBus() (*Conn, error) {
+	addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if addr == "" {
+		addr = defaultSystemBusAddress
+	}
+	return Dial(addr)
+}
+
+// Dial connects to the message bus at addr, using the first unix transport address
+// with a path or abstract socket name
+func Dial(addr string) (*Conn, error) {
+	path := ""
+	for _, a := range strings.Split(addr, ";") {
+		if !strings.HasPrefix(a, "unix:") {
+			continue
+		}
+		for _, kv := range strings.Split(strings.TrimPrefix(a, "unix:"), ",") {
+			key, val, _ := strings.Cut(kv, "=")
+			val, err := url.PathUnescape(val)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "path":
+				path = val
+			case "abstract":
+				path = "@" + val
+			}
+		}
+		if path != "" {
+			break
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("Unsupported bus address %q", addr)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("net.Dial: %v", err)
+	}
+	c := &Conn{conn: conn, r: bufio.NewReader(conn)}
+	if err := c.auth(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", ""); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Conn.Call: %v", err)
+	}
+	return c, nil
+}
+
+// auth authenticates as the user of the process using the EXTERNAL mechanism
+func (c *Conn) auth() error {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := c.conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		return fmt.Errorf("net.Conn.Write: %v", err)
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("bufio.Reader.ReadString: %v", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("Authentication rejected: %s", strings.TrimSpace(line))
+	}
+	if _, err := c.conn.Write([]byte("BEGIN\r\n")); err != nil {
+		return fmt.Errorf("net.Conn.Write: %v", err)
+	}
+	return nil
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Call calls a method and waits for its reply. The arguments are encoded according
+// to sig, where arrays and structs are given as []interface{} and byte arrays as []byte.
+// Error replies are returned as *Error.
+func (c *Conn) Call(dest, path, iface, member, sig string, args ...interface{}) error {
+	body := &encoder{}
+	rest := sig
+	for _, arg := range args {
+		var err error
+		if rest, err = body.value(rest, arg); err != nil {
+			return err
+		}
+	}
+	if rest != "" {
+		return errors.New("Too few arguments for signature")
+	}
+
+	c.serial++
+	e := &encoder{}
+	e.buf = append(e.buf, 'l', typeMethodCall, 0, 1)
+	e.uint32(uint32(len(body.buf)))
+	e.uint32(c.serial)
+	e.array(8, func() {
+		e.field(fieldPath, "o", path)
+		e.field(fieldInterface, "s", iface)
+		e.field(fieldMember, "s", member)
+		e.field(fieldDestination, "s", dest)
+		if sig != "" {
+			e.field(fieldSignature, "g", sig)
+		}
+	})
+	e.align(8)
+	if _, err := c.conn.Write(append(e.buf, body.buf...)); err != nil {
+		return fmt.Errorf("net.Conn.Write: %v", err)
+	}
+
+	for {
+		msgType, replySerial, errName, errMsg, err := c.read()
+		if err != nil {
+			return err
+		}
+		if replySerial != c.serial {
+			continue
+		}
+		switch msgType {
+		case typeMethodReturn:
+			return nil
+		case typeError:
+			return &Error{Name: errName, Message: errMsg}
+		}
+	}
+}
+
+// read reads a message and decodes the header fields needed to match replies to calls.
+// For errors, the message is the first argument if it is a string.
+func (c *Conn) read() (uint8, uint32, string, string, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, fixed); err != nil {
+		return 0, 0, "", "", fmt.Errorf("io.ReadFull: %v", err)
+	}
+
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return 0, 0, "", "", fmt.Errorf("Invalid byte order %q", fixed[0])
+	}
+	bodyLen := int(order.Uint32(fixed[4:8]))
+	fieldsLen := int(order.Uint32(fixed[12:16]))
+	headerLen := (16 + fieldsLen + 7) &^ 7
+	if fieldsLen > maxMessageLen || bodyLen > maxMessageLen-headerLen {
+		return 0, 0, "", "", errors.New("Message too long")
+	}
+
+	msg := make([]byte, headerLen+bodyLen)
+	copy(msg, fixed)
+	if _, err := io.ReadFull(c.r, msg[16:]); err != nil {
+		return 0, 0, "", "", fmt.Errorf("io.ReadFull: %v", err)
+	}
+
+	d := &decoder{buf: msg[:16+fieldsLen], order: order, off: 16}
+	var replySerial uint32
+	var errName, sig string
+	for d.off < len(d.buf) {
+		d.align(8)
+		code, err := d.byte()
+		if err != nil {
+			return 0, 0, "", "", err
+		}
+		fieldSig, err := d.signature()
+		if err != nil {
+			return 0, 0, "", "", err
+		}
+		switch fieldSig {
+		case "u":
+			v, err := d.uint32()
+			if err != nil {
+				return 0, 0, "", "", err
+			}
+			if code == fieldReplySerial {
+				replySerial = v
+			}
+		case "s", "o":
+			v, err := d.string()
+			if err != nil {
+				return 0, 0, "", "", err
+			}
+			if code == fieldErrorName {
+				errName = v
+			}
+		case "g":
+			v, err := d.signature()
+			if err != nil {
+				return 0, 0, "", "", err
+			}
+			if code == fieldSignature {
+				sig = v
+			}
+		default:
+			return 0, 0, "", "", fmt.Errorf("Unsupported header field signature %q", fieldSig)
+		}
+	}
+
+	var errMsg string
+	if fixed[1] == typeError && strings.HasPrefix(sig, "s") {
+		body := &decoder{buf: msg[headerLen:], order: order}
+		errMsg, _ = body.string()
+	}
+	return fixed[1], replySerial, errName, errMsg, nil
+}
+
+// encoder encodes values in little endian byte order, aligned relative to the start of buf
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *encoder) signature(s string) {
+	e.buf = append(e.buf, uint8(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+// array encodes an array whose elements are aligned to elemAlign and encoded by fn
+func (e *encoder) array(elemAlign int, fn func()) {
+	e.align(4)
+	pos := len(e.buf)
+	e.buf = append(e.buf, 0, 0, 0, 0)
+	e.align(elemAlign)
+	start := len(e.buf)
+	fn()
+	binary.LittleEndian.PutUint32(e.buf[pos:], uint32(len(e.buf)-start))
+}
+
+// field encodes a header field, which is a struct of a code and a variant
+func (e *encoder) field(code uint8, sig string, v string) {
+	e.align(8)
+	e.buf = append(e.buf, code)
+	e.signature(sig)
+	if sig == "g" {
+		e.signature(v)
+	} else {
+		e.string(v)
+	}
+}
+
+// value encodes v as the first complete type of sig and returns the rest of sig
+func (e *encoder) value(sig string, v interface{}) (string, error) {
+	if sig == "" {
+		return "", errors.New("Too many arguments for signature")
+	}
+
+	switch sig[0] {
+	case 'y':
+		b, ok := v.(uint8)
+		if !ok {
+			return "", fmt.Errorf("Invalid value %v for type y", v)
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return "", fmt.Errorf("Invalid value %v for type b", v)
+		}
+		if b {
+			e.uint32(1)
+		} else {
+			e.uint32(0)
+		}
+	case 'i':
+		i, ok := v.(int32)
+		if !ok {
+			return "", fmt.Errorf("Invalid value %v for type i", v)
+		}
+		e.uint32(uint32(i))
+	case 'u':
+		u, ok := v.(uint32)
+		if !ok {
+			return "", fmt.Errorf("Invalid value %v for type u", v)
+		}
+		e.uint32(u)
+	case 's', 'o':
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("Invalid value %v for type %c", v, sig[0])
+		}
+		e.string(s)
+	case 'a':
+		elem, rest, err := nextType(sig[1:])
+		if err != nil {
+			return "", err
+		}
+		if b, ok := v.([]byte); ok && elem == "y" {
+			e.array(1, func() { e.buf = append(e.buf, b...) })
+			return rest, nil
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			return "", fmt.Errorf("Invalid value %v for type a%s", v, elem)
+		}
+		e.array(alignment(elem[0]), func() {
+			for _, item := range items {
+				if err == nil {
+					_, err = e.value(elem, item)
+				}
+			}
+		})
+		return rest, err
+	case '(':
+		st, rest, err := nextType(sig)
+		if err != nil {
+			return "", err
+		}
+		fields, ok := v.([]interface{})
+		if !ok {
+			return "", fmt.Errorf("Invalid value %v for type %s", v, st)
+		}
+		e.align(8)
+		inner := st[1 : len(st)-1]
+		for _, f := range fields {
+			if inner, err = e.value(inner, f); err != nil {
+				return "", err
+			}
+		}
+		if inner != "" {
+			return "", fmt.Errorf("Too few fields for type %s", st)
+		}
+		return rest, nil
+	default:
+		return "", fmt.Errorf("Unsupported type %c", sig[0])
+	}
+	return sig[1:], nil
+}
+
+// nextType splits the first complete type from sig
+func nextType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", errors.New("Invalid signature")
+	}
+	switch sig[0] {
+	case 'a':
+		elem, _, err := nextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return sig[:1+len(elem)], sig[1+len(elem):], nil
+	case '(':
+		n := 1
+		for n < len(sig) && sig[n] != ')' {
+			t, _, err := nextType(sig[n:])
+			if err != nil {
+				return "", "", err
+			}
+			n += len(t)
+		}
+		if n >= len(sig) {
+			return "", "", errors.New("Invalid signature")
+		}
+		return sig[:n+1], sig[n+1:], nil
+	}
+	return sig[:1], sig[1:], nil
+}
+
+// alignment returns the alignment of a type by its first signature character
+func alignment(t byte) int {
+	switch t {
+	case 'y', 'g', 'v':
+		return 1
+	case '(', '{', 'x', 't', 'd':
+		return 8
+	}
+	return 4
+}
+
+// decoder decodes basic values, aligned relative to the start of buf
+type decoder struct {
+	buf   []byte
+	order binary.ByteOrder
+	off   int
+}
+
+var errTruncated = errors.New("Truncated message")
+
+func (d *decoder) align(n int) {
+	d.off = (d.off + n - 1) &^ (n - 1)
+}
+
+func (d *decoder) byte() (uint8, error) {
+	if d.off >= len(d.buf) {
+		return 0, errTruncated
+	}
+	d.off++
+	return d.buf[d.off-1], nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	d.align(4)
+	if d.off+4 > len(d.buf) {
+		return 0, errTruncated
+	}
+	d.off += 4
+	return d.order.Uint32(d.buf[d.off-4:]), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+	if int(n) < 0 || d.off+int(n)+1 > len(d.buf) {
+		return "", errTruncated
+	}
+	d.off += int(n) + 1
+	return string(d.buf[d.off-int(n)-1 : d.off-1]), nil
+}
+
+func (d *decoder) signature() (string, error) {
+	n, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+	if d.off+int(n)+1 > len(d.buf) {
+		return "", errTruncated
+	}
+	d.off += int(n) + 1
+	return string(d.buf[d.off-int(n)-1 : d.off-1]), nil
+}
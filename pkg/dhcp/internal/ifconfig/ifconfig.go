@@ -0,0 +1,70 @@
+package ifconfig
+
+import (
+	"net"
+	"time"
+)
+
+// Config is the IPv4 configuration of an interface obtained from a lease
+type Config struct {
+	IP   net.IP
+	Mask net.IPMask // if nil, the default mask of IP is used
+
+	// Lifetime is the remaining lifetime of the address, after which it is removed
+	// by the system. A zero value means the address does not expire.
+	Lifetime time.Duration
+
+	Routes []Route
+	MTU    int // if zero, the MTU of the interface is not changed
+}
+
+// Route is a route through the interface. A nil Gateway or a Gateway of
+// 0.0.0.0 means the destination is directly reachable on the interface.
+type Route struct {
+	Dest    *net.IPNet // if nil, the route is the default route
+	Gateway net.IP
+}
+
+// equal reports whether r and o are the same route
+func (r Route) equal(o Route) bool {
+	dest := func(r Route) string {
+		if r.Dest == nil {
+			return "0.0.0.0/0"
+		}
+		return (&net.IPNet{IP: r.Dest.IP.Mask(r.Dest.Mask), Mask: r.Dest.Mask}).String()
+	}
+	gateway := func(r Route) net.IP {
+		if gw := r.Gateway.To4(); gw != nil && !gw.Equal(net.IPv4zero) {
+			return gw
+		}
+		return nil
+	}
+	return dest(r) == dest(o) && gateway(r).Equal(gateway(o))
+}
+
+// hasRoute reports whether the configuration contains route
+func (c *Config) hasRoute(route Route) bool {
+	for _, r := range c.Routes {
+		if r.equal(route) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefix returns the IPv4 address and prefix length of the configuration
+func (c *Config) prefix() (net.IP, int, bool) {
+	ip := c.IP.To4()
+	if ip == nil {
+		return nil, 0, false
+	}
+	mask := c.Mask
+	if mask == nil {
+		mask = ip.DefaultMask()
+	}
+	ones, bits := mask.Size()
+	if bits != 32 {
+		return nil, 0, false
+	}
+	return ip, ones, true
+}
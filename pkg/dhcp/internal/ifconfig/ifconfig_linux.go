@@ -1,3 +1,328 @@
 package ifconfig
 
-// TODO: Implement interface IP configuration for DHCP client
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// infiniteLifetime is the address lifetime which never expires
+const infiniteLifetime = math.MaxUint32
+
+// rtnl is a connection to the kernel routing subsystem over rtnetlink
+type rtnl struct {
+	fd  int
+	seq uint32
+}
+
+func dialRtnl() (*rtnl, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("syscall.Socket: %v", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("syscall.Bind: %v", err)
+	}
+	return &rtnl{fd: fd}, nil
+}
+
+func (r *rtnl) Close() error {
+	return syscall.Close(r.fd)
+}
+
+// request sends a request to the kernel and waits for it to be acknowledged.
+// Errors reported by the kernel are returned as syscall.Errno.
+func (r *rtnl) request(typ, flags uint16, data []byte) error {
+	r.seq++
+
+	msg := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(data))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(syscall.NLMSG_HDRLEN+len(data)))
+	binary.NativeEndian.PutUint16(msg[4:6], typ)
+	binary.NativeEndian.PutUint16(msg[6:8], flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], r.seq)
+	msg = append(msg, data...)
+
+	if err := syscall.Sendto(r.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("syscall.Sendto: %v", err)
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(r.fd, buf, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("syscall.Recvfrom: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("syscall.ParseNetlinkMessage: %v", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != r.seq || m.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return errors.New("Truncated netlink error message")
+			}
+			if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+// appendAttr appends a routing attribute, padded to a multiple of 4 bytes
+func appendAttr(b []byte, typ uint16, val []byte) []byte {
+	b = binary.NativeEndian.AppendUint16(b, uint16(syscall.SizeofRtAttr+len(val)))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = append(b, val...)
+	for len(b)%syscall.RTA_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// addrMessage creates an ifaddrmsg for the address of the configuration
+func addrMessage(index int, cfg *Config) ([]byte, error) {
+	ip, ones, ok := cfg.prefix()
+	if !ok {
+		return nil, errors.New("Invalid IPv4 address or mask")
+	}
+
+	b := []byte{syscall.AF_INET, uint8(ones), 0, syscall.RT_SCOPE_UNIVERSE}
+	b = binary.NativeEndian.AppendUint32(b, uint32(index))
+	b = appendAttr(b, syscall.IFA_LOCAL, ip)
+	b = appendAttr(b, syscall.IFA_ADDRESS, ip)
+	if ones < 31 {
+		brd := make(net.IP, 4)
+		mask := net.CIDRMask(ones, 32)
+		for i := range brd {
+			brd[i] = ip[i] | ^mask[i]
+		}
+		b = appendAttr(b, syscall.IFA_BROADCAST, brd)
+	}
+	return b, nil
+}
+
+// lifetime returns the lifetime of the address in seconds, rounded up
+func lifetime(d time.Duration) uint32 {
+	if d <= 0 || d >= infiniteLifetime*time.Second {
+		return infiniteLifetime
+	}
+	return uint32((d + time.Second - 1) / time.Second)
+}
+
+// newAddr adds the address of the configuration to the interface, or replaces it to
+// update its lifetime. The kernel removes the address once its lifetime has passed.
+func (r *rtnl) newAddr(index int, cfg *Config) error {
+	b, err := addrMessage(index, cfg)
+	if err != nil {
+		return err
+	}
+
+	// struct ifa_cacheinfo: preferred and valid lifetime, followed by timestamps
+	cacheinfo := make([]byte, 16)
+	binary.NativeEndian.PutUint32(cacheinfo[0:4], lifetime(cfg.Lifetime))
+	binary.NativeEndian.PutUint32(cacheinfo[4:8], lifetime(cfg.Lifetime))
+	b = appendAttr(b, syscall.IFA_CACHEINFO, cacheinfo)
+
+	return r.request(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, b)
+}
+
+// delAddr removes the address of the configuration from the interface
+func (r *rtnl) delAddr(index int, cfg *Config) error {
+	b, err := addrMessage(index, cfg)
+	if err != nil {
+		return err
+	}
+	return r.request(syscall.RTM_DELADDR, 0, b)
+}
+
+// routeMessage creates an rtmsg for a route through the interface with the preferred source address src
+func routeMessage(index int, src net.IP, route Route) ([]byte, error) {
+	dst := &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	if route.Dest != nil {
+		dst = route.Dest
+	}
+	ones, bits := dst.Mask.Size()
+	if bits != 32 || dst.IP.To4() == nil {
+		return nil, fmt.Errorf("Invalid route destination %s", dst)
+	}
+
+	scope := uint8(syscall.RT_SCOPE_UNIVERSE)
+	gw := route.Gateway.To4()
+	if gw == nil || gw.Equal(net.IPv4zero) {
+		scope = syscall.RT_SCOPE_LINK
+		gw = nil
+	}
+
+	b := []byte{syscall.AF_INET, uint8(ones), 0, 0, syscall.RT_TABLE_MAIN, syscall.RTPROT_DHCP, scope, syscall.RTN_UNICAST}
+	b = binary.NativeEndian.AppendUint32(b, 0)
+	if ones > 0 {
+		b = appendAttr(b, syscall.RTA_DST, dst.IP.To4().Mask(dst.Mask))
+	}
+	if gw != nil {
+		b = appendAttr(b, syscall.RTA_GATEWAY, gw)
+	}
+	b = appendAttr(b, syscall.RTA_PREFSRC, src)
+	b = appendAttr(b, syscall.RTA_OIF, binary.NativeEndian.AppendUint32(nil, uint32(index)))
+	return b, nil
+}
+
+// newRoute adds a route through the interface. Routes to the same destination through
+// other interfaces are kept, and adding a route which already exists is not an error.
+func (r *rtnl) newRoute(index int, src net.IP, route Route) error {
+	b, err := routeMessage(index, src, route)
+	if err != nil {
+		return err
+	}
+	if err := r.request(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE, b); err != nil && err != syscall.EEXIST {
+		return err
+	}
+	return nil
+}
+
+// delRoute removes a route through the interface
+func (r *rtnl) delRoute(index int, src net.IP, route Route) error {
+	b, err := routeMessage(index, src, route)
+	if err != nil {
+		return err
+	}
+	return r.request(syscall.RTM_DELROUTE, 0, b)
+}
+
+// setMTU sets the MTU of the interface
+func (r *rtnl) setMTU(index int, mtu int) error {
+	// struct ifinfomsg: family, padding, type, index, flags and change mask
+	b := make([]byte, syscall.SizeofIfInfomsg)
+	b[0] = syscall.AF_UNSPEC
+	binary.NativeEndian.PutUint32(b[4:8], uint32(index))
+	b = appendAttr(b, syscall.IFLA_MTU, binary.NativeEndian.AppendUint32(nil, uint32(mtu)))
+	return r.request(syscall.RTM_SETLINK, 0, b)
+}
+
+// sortRoutes returns the routes with directly reachable destinations first,
+// so the gateways of the other routes are reachable when they are added
+func sortRoutes(routes []Route) []Route {
+	sorted := append([]Route{}, routes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		onlink := func(r Route) bool {
+			return r.Gateway.To4() == nil || r.Gateway.Equal(net.IPv4zero)
+		}
+		return onlink(sorted[i]) && !onlink(sorted[j])
+	})
+	return sorted
+}
+
+// Apply configures the interface with the MTU, address and routes of cfg. Applying
+// a configuration again replaces the address, updating its lifetime.
+func Apply(lif *net.Interface, cfg *Config) error {
+	ip, _, ok := cfg.prefix()
+	if !ok {
+		return errors.New("Invalid IPv4 address or mask")
+	}
+
+	r, err := dialRtnl()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if cfg.MTU > 0 {
+		if err := r.setMTU(lif.Index, cfg.MTU); err != nil {
+			return fmt.Errorf("rtnl.setMTU: %v", err)
+		}
+	}
+	if err := r.newAddr(lif.Index, cfg); err != nil {
+		return fmt.Errorf("rtnl.newAddr: %v", err)
+	}
+	for _, route := range sortRoutes(cfg.Routes) {
+		if err := r.newRoute(lif.Index, ip, route); err != nil {
+			return fmt.Errorf("rtnl.newRoute: %v", err)
+		}
+	}
+	return nil
+}
+
+// Remove removes the routes and address of cfg from the interface, and restores
+// the MTU of lif if cfg changed it. Routes and addresses which are already gone,
+// for example because the lifetime of the address has passed, are ignored.
+func Remove(lif *net.Interface, cfg *Config) error {
+	ip, _, ok := cfg.prefix()
+	if !ok {
+		return errors.New("Invalid IPv4 address or mask")
+	}
+
+	r, err := dialRtnl()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var firstErr error
+	routes := sortRoutes(cfg.Routes)
+	for i := len(routes) - 1; i >= 0; i-- {
+		if err := r.delRoute(lif.Index, ip, routes[i]); err != nil && err != syscall.ESRCH && firstErr == nil {
+			firstErr = fmt.Errorf("rtnl.delRoute: %v", err)
+		}
+	}
+	if err := r.delAddr(lif.Index, cfg); err != nil && err != syscall.EADDRNOTAVAIL && firstErr == nil {
+		firstErr = fmt.Errorf("rtnl.delAddr: %v", err)
+	}
+	if cfg.MTU > 0 && lif.MTU > 0 && cfg.MTU != lif.MTU {
+		if err := r.setMTU(lif.Index, lif.MTU); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("rtnl.setMTU: %v", err)
+		}
+	}
+	return firstErr
+}
+
+// Update changes the configuration of the interface from prev to cfg. If the address
+// is unchanged, the routes of prev which are not in cfg are removed, and the MTU of
+// lif is restored if cfg no longer changes it, before cfg is applied. Otherwise prev
+// is removed first.
+func Update(lif *net.Interface, prev, cfg *Config) error {
+	prevIP, prevOnes, _ := prev.prefix()
+	ip, ones, ok := cfg.prefix()
+	if !ok {
+		return errors.New("Invalid IPv4 address or mask")
+	}
+	if !prevIP.Equal(ip) || prevOnes != ones {
+		if err := Remove(lif, prev); err != nil {
+			return err
+		}
+		return Apply(lif, cfg)
+	}
+
+	r, err := dialRtnl()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	routes := sortRoutes(prev.Routes)
+	for i := len(routes) - 1; i >= 0; i-- {
+		if cfg.hasRoute(routes[i]) {
+			continue
+		}
+		if err := r.delRoute(lif.Index, ip, routes[i]); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("rtnl.delRoute: %v", err)
+		}
+	}
+	if cfg.MTU == 0 && prev.MTU > 0 && lif.MTU > 0 && prev.MTU != lif.MTU {
+		if err := r.setMTU(lif.Index, lif.MTU); err != nil {
+			return fmt.Errorf("rtnl.setMTU: %v", err)
+		}
+	}
+	return Apply(lif, cfg)
+}
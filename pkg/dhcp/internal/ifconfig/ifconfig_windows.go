@@ -1,3 +1,21 @@
 package ifconfig
 
-// TODO: Implement interface IP configuration for DHCP client
+import (
+	"errors"
+	"net"
+)
+
+// Apply is not supported on Windows
+func Apply(lif *net.Interface, cfg *Config) error {
+	return errors.New("interface configuration is not supported on windows")
+}
+
+// Remove is not supported on Windows
+func Remove(lif *net.Interface, cfg *Config) error {
+	return errors.New("interface configuration is not supported on windows")
+}
+
+// Update is not supported on Windows
+func Update(lif *net.Interface, prev, cfg *Config) error {
+	return errors.New("interface configuration is not supported on windows")
+}
@@ -3,17 +3,21 @@ package ifnet
 import (
 	"net"
 	"syscall"
+	"time"
 )
 
-type conn struct {
-	fd      syscall.Handle
-	network string
-}
-
 type Conn interface {
 	Close() error
 }
 
+// PacketConn is a UDP connection implemented by both UDPConn and RawConn
+type PacketConn interface {
+	Conn
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(p []byte, raddr *net.UDPAddr) (int, error)
+	SetReadDeadline(t time.Time) error
+}
+
 type UDPConn struct {
 	conn
 }
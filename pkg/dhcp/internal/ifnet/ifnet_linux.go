@@ -0,0 +1,99 @@
+package ifnet
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type conn struct {
+	mu       sync.RWMutex // held for reading during system calls on fd, and for writing to close it
+	fd       int
+	closing  atomic.Bool
+	network  string
+	deadline time.Time
+}
+
+// control calls fn with the file descriptor of the connection, which is not
+// closed before fn returns. If the connection is closed, net.ErrClosed is returned.
+func (c *conn) control(fn func(fd int) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closing.Load() {
+		return net.ErrClosed
+	}
+	return fn(c.fd)
+}
+
+// close shuts down and closes the file descriptor. Shutting down the socket
+// first wakes up reads blocked in recvfrom, which would otherwise keep the
+// socket open until they return. Sockets which can not be shut down, such as
+// AF_PACKET sockets, are closed once blocked reads reach their deadline.
+func (c *conn) close() error {
+	if c.closing.Swap(true) {
+		return nil
+	}
+
+	c.mu.RLock()
+	syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return syscall.Close(c.fd)
+}
+
+// SetReadDeadline sets the deadline for future reads using SO_RCVTIMEO.
+// Reads which time out return os.ErrDeadlineExceeded. A zero value disables the deadline.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+
+	var tv syscall.Timeval
+	if !t.IsZero() {
+		d := time.Until(t)
+		if d < time.Microsecond {
+			d = time.Microsecond // a zero timeout would block forever
+		}
+		tv = syscall.NsecToTimeval(d.Nanoseconds())
+	}
+	return c.control(func(fd int) error {
+		return syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	})
+}
+
+// recvfrom calls syscall.Recvfrom, retrying when interrupted by a signal
+func (c *conn) recvfrom(b []byte) (n int, from syscall.Sockaddr, err error) {
+	cerr := c.control(func(fd int) error {
+		for {
+			n, from, err = syscall.Recvfrom(fd, b, 0)
+			if err != syscall.EINTR {
+				return nil
+			}
+		}
+	})
+	switch {
+	case cerr != nil:
+		return 0, nil, cerr
+	case c.closing.Load():
+		return 0, nil, net.ErrClosed
+	case err == syscall.EAGAIN:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+	return n, from, err
+}
+
+// sendto calls syscall.Sendto, retrying when interrupted by a signal
+func (c *conn) sendto(p []byte, to syscall.Sockaddr) error {
+	return c.control(func(fd int) error {
+		for {
+			err := syscall.Sendto(fd, p, 0, to)
+			if err != syscall.EINTR {
+				return err
+			}
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package ifnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	ethHeaderLen  = 14
+	ipv4HeaderLen = 20
+	udpHeaderLen  = 8
+	ethTypeIPv4   = 0x0800
+	maxFrameSize  = 65536
+)
+
+// RawConn is a UDP connection over an AF_PACKET socket. It builds and parses the
+// Ethernet, IPv4 and UDP headers itself, which allows sending and receiving
+// UDP packets on an interface that does not have an IP address yet.
+type RawConn struct {
+	conn
+	lif   *net.Interface
+	laddr *net.UDPAddr
+	id    uint16
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// checksum computes the internet checksum (RFC1071) of data, starting from sum
+func checksum(sum uint32, data []byte) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// udpFilter returns a BPF program accepting only unfragmented IPv4 UDP packets to port
+func udpFilter(port int) []syscall.SockFilter {
+	return []syscall.SockFilter{
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 12),                 // ethertype
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, ethTypeIPv4, 0, 8), // is IPv4?
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_ABS, 23),                 // IP protocol
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.IPPROTO_UDP, 0, 6),
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 20),             // flags and fragment offset
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, 0x1fff, 4, 0), // is fragment?
+		*syscall.LsfStmt(syscall.BPF_LDX|syscall.BPF_B|syscall.BPF_MSH, ethHeaderLen),  // IP header length
+		*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_IND, ethHeaderLen+2), // UDP destination port
+		*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, port, 0, 1),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, maxFrameSize),
+		*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0),
+	}
+}
+
+func (c *RawConn) Close() error {
+	return c.close()
+}
+
+// WriteToUDP sends p to raddr. As no address resolution is performed,
+// frames are always sent to the Ethernet broadcast address.
+func (c *RawConn) WriteToUDP(p []byte, raddr *net.UDPAddr) (int, error) {
+	dst := raddr.IP.To4()
+	if dst == nil {
+		return 0, errors.New("invalid destination address")
+	}
+	src := c.laddr.IP.To4()
+	if src == nil {
+		src = net.IPv4zero.To4()
+	}
+	if ipv4HeaderLen+udpHeaderLen+len(p) > 0xffff {
+		return 0, errors.New("packet too large")
+	}
+
+	frame := make([]byte, ethHeaderLen+ipv4HeaderLen+udpHeaderLen+len(p))
+
+	// Ethernet header
+	eth := frame[:ethHeaderLen]
+	copy(eth[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(eth[6:12], c.lif.HardwareAddr)
+	binary.BigEndian.PutUint16(eth[12:14], ethTypeIPv4)
+
+	// IPv4 header
+	c.id++
+	ip := frame[ethHeaderLen : ethHeaderLen+ipv4HeaderLen]
+	ip[0] = 0x45 // version 4, header length 5 words
+	binary.BigEndian.PutUint16(ip[2:4], uint16(ipv4HeaderLen+udpHeaderLen+len(p)))
+	binary.BigEndian.PutUint16(ip[4:6], c.id)
+	ip[8] = 64 // TTL
+	ip[9] = syscall.IPPROTO_UDP
+	copy(ip[12:16], src)
+	copy(ip[16:20], dst)
+	binary.BigEndian.PutUint16(ip[10:12], checksum(0, ip))
+
+	// UDP header
+	udp := frame[ethHeaderLen+ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:2], uint16(c.laddr.Port))
+	binary.BigEndian.PutUint16(udp[2:4], uint16(raddr.Port))
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHeaderLen+len(p)))
+	copy(udp[udpHeaderLen:], p)
+
+	// UDP checksum over IPv4 pseudo header
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], src)
+	copy(pseudo[4:8], dst)
+	pseudo[9] = syscall.IPPROTO_UDP
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(udpHeaderLen+len(p)))
+	var sum uint32
+	for i := 0; i < len(pseudo); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(pseudo[i:]))
+	}
+	csum := checksum(sum, udp)
+	if csum == 0 {
+		csum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:8], csum)
+
+	sa := &syscall.SockaddrLinklayer{
+		Protocol: htons(ethTypeIPv4),
+		Ifindex:  c.lif.Index,
+		Halen:    6,
+	}
+	copy(sa.Addr[:], eth[0:6])
+
+	if err := c.sendto(frame, sa); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFromUDP reads the payload of the next valid UDP packet into b
+func (c *RawConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	frame := make([]byte, maxFrameSize)
+
+	for {
+		n, _, err := c.recvfrom(frame)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		payload, raddr, ok := c.parseFrame(frame[:n])
+		if !ok {
+			// the timeout restarts with every frame, so apply the remaining time
+			if !c.deadline.IsZero() {
+				if time.Now().After(c.deadline) {
+					return 0, nil, os.ErrDeadlineExceeded
+				}
+				if err := c.SetReadDeadline(c.deadline); err != nil {
+					return 0, nil, err
+				}
+			}
+			continue
+		}
+
+		return copy(b, payload), raddr, nil
+	}
+}
+
+// parseFrame validates an Ethernet frame containing an IPv4 UDP packet
+// to the local port and returns the UDP payload and source address
+func (c *RawConn) parseFrame(frame []byte) ([]byte, *net.UDPAddr, bool) {
+	if len(frame) < ethHeaderLen+ipv4HeaderLen+udpHeaderLen {
+		return nil, nil, false
+	}
+	if binary.BigEndian.Uint16(frame[12:14]) != ethTypeIPv4 {
+		return nil, nil, false
+	}
+
+	ip := frame[ethHeaderLen:]
+	if ip[0]>>4 != 4 || ip[9] != syscall.IPPROTO_UDP {
+		return nil, nil, false
+	}
+	ihl := int(ip[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(ip[2:4]))
+	if ihl < ipv4HeaderLen || totalLen < ihl+udpHeaderLen || totalLen > len(ip) {
+		return nil, nil, false
+	}
+	if checksum(0, ip[:ihl]) != 0 {
+		return nil, nil, false
+	}
+
+	udp := ip[ihl:totalLen]
+	if int(binary.BigEndian.Uint16(udp[2:4])) != c.laddr.Port {
+		return nil, nil, false
+	}
+	udpLen := int(binary.BigEndian.Uint16(udp[4:6]))
+	if udpLen < udpHeaderLen || udpLen > len(udp) {
+		return nil, nil, false
+	}
+
+	raddr := &net.UDPAddr{
+		IP:   net.IPv4(ip[12], ip[13], ip[14], ip[15]),
+		Port: int(binary.BigEndian.Uint16(udp[0:2])),
+	}
+	return udp[udpHeaderLen:udpLen], raddr, true
+}
+
+// ListenRawUDP opens an AF_PACKET socket on lif which receives IPv4 UDP packets
+// sent to the port in laddr, regardless of the IP addresses configured on lif.
+// Requires CAP_NET_RAW.
+func ListenRawUDP(laddr *net.UDPAddr, lif *net.Interface) (*RawConn, error) {
+	if lif == nil {
+		return nil, errors.New("interface required for raw socket")
+	}
+	if len(lif.HardwareAddr) != 6 {
+		return nil, errors.New("interface is not an Ethernet interface")
+	}
+
+	// open the socket without a protocol, so no packets are
+	// queued before the filter is attached and the socket is bound
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		syscall.ForkLock.RUnlock()
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	syscall.ForkLock.RUnlock()
+
+	c := &RawConn{
+		conn: conn{
+			network: "udp4",
+			fd:      fd,
+		},
+		lif:   lif,
+		laddr: laddr,
+	}
+
+	if err := syscall.AttachLsf(fd, udpFilter(laddr.Port)); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{
+		Protocol: htons(ethTypeIPv4),
+		Ifindex:  lif.Index,
+	}); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
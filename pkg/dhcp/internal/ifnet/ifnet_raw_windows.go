@@ -0,0 +1,16 @@
+package ifnet
+
+import (
+	"errors"
+	"net"
+)
+
+// RawConn is not supported on Windows
+type RawConn struct {
+	UDPConn
+}
+
+// ListenRawUDP is not supported on Windows
+func ListenRawUDP(laddr *net.UDPAddr, lif *net.Interface) (*RawConn, error) {
+	return nil, errors.New("raw sockets are not supported on windows")
+}
@@ -1,3 +1,80 @@
 package ifnet
 
-// TODO: Implement linux equivalent
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func (c *UDPConn) Close() error {
+	return c.close()
+}
+
+func (c *UDPConn) WriteToUDP(p []byte, raddr *net.UDPAddr) (int, error) {
+	sa := sockaddrFromAddr(raddr)
+	if sa == nil {
+		return 0, errors.New("invalid destination address")
+	}
+
+	if err := c.sendto(p, sa); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *UDPConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	n, raddr, err := c.recvfrom(b)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	addr := sockaddrToAddr(c.network, raddr)
+	if _, ok := addr.(*net.UDPAddr); !ok {
+		return 0, nil, errors.New("invalid source address")
+	}
+
+	return n, addr.(*net.UDPAddr), nil
+}
+
+// ListenUDP acts like net.ListenUDP, with the following exceptions:
+//
+//   - It additionally takes a local interface to listen on, which the socket
+//     is bound to using SO_BINDTODEVICE (requires CAP_NET_RAW)
+//   - You may listen on an unspecified address (0.0.0.0/32 or ::/128)
+func ListenUDP(network string, laddr *net.UDPAddr, lif *net.Interface) (*UDPConn, error) {
+	syscall.ForkLock.RLock()
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		syscall.ForkLock.RUnlock()
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	syscall.ForkLock.RUnlock()
+
+	c := &UDPConn{conn: conn{
+		network: network,
+		fd:      fd,
+	}}
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if lif != nil {
+		if err := syscall.BindToDevice(fd, lif.Name); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if err = syscall.Bind(fd, sockaddrFromAddr(laddr)); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -3,6 +3,7 @@ package ifnet
 import (
 	"errors"
 	"net"
+	"os"
 	"syscall"
 	"unsafe"
 )
@@ -45,6 +46,9 @@ func (c *UDPConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
 	srclen := int32(unsafe.Sizeof(*src))
 
 	if err := syscall.WSARecvFrom(c.fd, buf, 1, &recvd, &flags, src, &srclen, nil, nil); err != nil {
+		if err == wsaeTimedout {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
 		return 0, nil, err
 	}
 
@@ -0,0 +1,30 @@
+package ifnet
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	soRcvtimeo   = 0x1006
+	wsaeTimedout = syscall.Errno(10060)
+)
+
+type conn struct {
+	fd      syscall.Handle
+	network string
+}
+
+// SetReadDeadline sets the deadline for future reads using SO_RCVTIMEO.
+// Reads which time out return os.ErrDeadlineExceeded. A zero value disables the deadline.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	var ms uint32
+	if !t.IsZero() {
+		ms = uint32(time.Until(t) / time.Millisecond)
+		if ms == 0 {
+			ms = 1 // a zero timeout would block forever
+		}
+	}
+	return syscall.Setsockopt(c.fd, syscall.SOL_SOCKET, soRcvtimeo, (*byte)(unsafe.Pointer(&ms)), int32(unsafe.Sizeof(ms)))
+}